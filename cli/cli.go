@@ -3,7 +3,9 @@ package cli
 import (
 	"flag"
 	"fmt"
+	"go/token"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/apleshkov/tmtr/gen"
@@ -13,12 +15,14 @@ func newUsage(fs *flag.FlagSet) func() {
 	wr := fs.Output()
 	return func() {
 		fmt.Fprintf(wr, "Usage of tmtr %s:\n", gen.Version)
-		fmt.Fprintf(wr, "  tmtr [-pkg name] -fn name -type type -in file [-mode mode] [-out file] [-tpl name[:type] ...] [-import ...] [-tplfn ...]\n")
+		fmt.Fprintf(wr, "  tmtr [-pkg name] -fn name -type type -in file[,...] [-mode mode] [-out file] [-tpl name[:type] ...] [-define name:fn ...] [-import ...] [-tplfn ...] [-diag format] [-line] [-comments] [-typecheck] [-types=false] [-sortmaps=false] [-safe] [-recover mode] [-errors] [-sticky=false]\n")
 		fmt.Fprintf(wr, "\nExamples:\n")
 		fmt.Fprintf(wr, "  # Basic usage:\n")
 		fmt.Fprintf(wr, "  tmtr -pkg \"main\" -fn \"RenderIndex\" -type \"any\" -in \"./index.html\"\n")
 		fmt.Fprintf(wr, "\n  # External templates:\n")
 		fmt.Fprintf(wr, "  tmtr -pkg \"main\" -fn \"RenderIndex\" -type \"any\" -in \"./index.html\" -tpl \"foo:Foo\" -tpl \"foo:map[string]any\"\n")
+		fmt.Fprintf(wr, "\n  # Layout with pages (e.g. \"RenderIndexAbout\" for \"about.html\"):\n")
+		fmt.Fprintf(wr, "  tmtr -pkg \"main\" -fn \"RenderIndex\" -type \"any\" -in \"./base.html,./pages/*.html\" -out \"./pages.go\"\n")
 		fmt.Fprintf(wr, "\n  # User template function and its neccessary import:\n")
 		fmt.Fprintf(wr, "  tmtr -pkg \"main\" -fn \"RenderIndex\" -type \"any\" -in \"./index.html\" -import \"strconv\" -tplfn \"strconv.Atoi\"\n")
 		fmt.Fprintf(wr, "\nFor more information, see:\n")
@@ -52,15 +56,28 @@ func newParser(fs *flag.FlagSet) parseFn {
 	pkg := fs.String("pkg", os.Getenv("GOPACKAGE"), "package name; optional: $GOPACKAGE by default (is set by go:generate)")
 	fnName := fs.String("fn", "", "[required] function name")
 	dataType := fs.String("type", "", "[required] data type")
-	inPath := fs.String("in", "", "path to the template file")
+	var inPaths strsVar
+	fs.Var(&inPaths, "in", `[required] [multiple] template files or globs parsed into one set, e.g. "base.html,pages/*.html"; the first one is the layout, and the ones redefining its templates are pages`)
 	modeStr := fs.String("mode", "", "'text' or 'html'; optional: 'html' is used if `in`'s extension ends with 'html' (e.g. 'foo.html', 'bar.gohtml'), 'text' otherwise")
 	outPath := fs.String("out", "", "path to the output *.go file; optional: adds '.go' to the `in` filename (e.g. 'foo.html' -> 'foo.html.go')")
 	var tpl strsVar
 	fs.Var(&tpl, "tpl", `[multiple] external template with type, e.g. "foo:Foo"; comma-separated is also supported, e.g. "baz:string,quux:[]int"`)
+	var defines strsVar
+	fs.Var(&defines, "define", `[multiple] function name of a defined template or page instead of the generated one, e.g. "nav-bar:RenderNavBar"; comma-separated is also supported`)
 	var imports strsVar
 	fs.Var(&imports, "import", `[multiple] additional imports, e.g. "net/http"; comma-separated is also supported, e.g. "fmt,strings"`)
 	var funcs strsVar
 	fs.Var(&funcs, "tplfn", `[multiple] user template functions; comma-separated is also supported, e.g. "foo,bar"`)
+	lineDirectives := fs.Bool("line", false, "emit //line directives, so compiler errors and stack traces point at the template")
+	comments := fs.Bool("comments", false, "comment generated statements with their template snippets")
+	loadTypes := fs.Bool("types", true, "resolve the data types using the output file's package (e.g. to tell fields from methods); falls back to the untyped mode if failed")
+	sortMaps := fs.Bool("sortmaps", true, "range over maps in the sorted key order like text/template does; disable if the order doesn't matter")
+	safe := fs.Bool("safe", false, "check nil pointers and the index/slice bounds, so failed actions write errors to errOutput instead of panicking")
+	recoverStr := fs.String("recover", "", "recover panics and write them to errOutput: 'func' skips the rest of the generated function, 'action' skips the failed action only")
+	returnErrors := fs.Bool("errors", false, "generate functions returning the first error like Template.Execute does, e.g. 'func RenderIndex(output io.Writer, data T) error', instead of writing the errors to errOutput")
+	stickyOutput := fs.Bool("sticky", true, "stop writing at the first failed write (e.g. a disconnected client): the error is written to errOutput once, and the loops exit early")
+	typeCheck := fs.Bool("typecheck", false, "type-check the generated code against the output file's package before writing it")
+	diagStr := fs.String("diag", "text", "diagnostics format: 'text' (compiler-style) or 'json' (JSON lines)")
 	return func(args []string) (*gen.GeneratorOptions, error) {
 		err := fs.Parse(args)
 		if err != nil {
@@ -76,17 +93,21 @@ func newParser(fs *flag.FlagSet) parseFn {
 			return nil, newBadFlag("no `type` provided")
 		}
 		*dataType = strings.TrimSpace(*dataType)
-		if len(*inPath) == 0 {
+		if len(inPaths) == 0 {
 			return nil, newBadFlag("no `in` provided")
 		}
+		inFiles, err := expandPaths(inPaths)
+		if err != nil {
+			return nil, err
+		}
 		if len(*modeStr) == 0 {
 			*modeStr = "text"
-			if strings.HasSuffix(*inPath, "html") {
+			if strings.HasSuffix(inFiles[0], "html") {
 				*modeStr = "html"
 			}
 		}
 		if len(*outPath) == 0 {
-			*outPath = strings.TrimSpace(*inPath) + ".go"
+			*outPath = inFiles[0] + ".go"
 		}
 		var mode gen.Mode
 		switch *modeStr {
@@ -97,6 +118,26 @@ func newParser(fs *flag.FlagSet) parseFn {
 		default:
 			return nil, newBadFlag("unknown `mode`: " + *modeStr)
 		}
+		var diag gen.DiagFormat
+		switch *diagStr {
+		case "text":
+			diag = gen.DiagText
+		case "json":
+			diag = gen.DiagJSON
+		default:
+			return nil, newBadFlag("unknown `diag` format: " + *diagStr)
+		}
+		var recoverMode gen.RecoverMode
+		switch *recoverStr {
+		case "":
+			recoverMode = gen.RecoverNone
+		case "func":
+			recoverMode = gen.RecoverFunc
+		case "action":
+			recoverMode = gen.RecoverAction
+		default:
+			return nil, newBadFlag("unknown `recover` mode: " + *recoverStr)
+		}
 		tmpls := make([]gen.NamedTemplateInfo, 0, len(tpl))
 		for _, s := range tpl {
 			if n, dt, ok := strings.Cut(s, ":"); ok {
@@ -111,20 +152,70 @@ func newParser(fs *flag.FlagSet) parseFn {
 				DataType: "",
 			})
 		}
+		var fnNames map[string]string
+		for _, s := range defines {
+			n, fn, ok := strings.Cut(s, ":")
+			n, fn = strings.TrimSpace(n), strings.TrimSpace(fn)
+			if !ok || len(n) == 0 || !token.IsIdentifier(fn) {
+				return nil, newBadFlag(fmt.Sprintf("bad `define` %q: it should be name:FuncName", s))
+			}
+			if fnNames == nil {
+				fnNames = make(map[string]string)
+			}
+			fnNames[n] = fn
+		}
 		return &gen.GeneratorOptions{
-			InFile:   *inPath,
-			OutFile:  *outPath,
-			Mode:     mode,
-			Package:  *pkg,
-			FnName:   *fnName,
-			DataType: *dataType,
-			Tmpls:    tmpls,
-			Imports:  imports,
-			Funcs:    funcs,
+			InFiles:    inFiles,
+			OutFile:    *outPath,
+			Mode:       mode,
+			Package:    *pkg,
+			FnName:     *fnName,
+			FnNames:    fnNames,
+			DataType:   *dataType,
+			Tmpls:      tmpls,
+			Imports:    imports,
+			Funcs:      funcs,
+			DiagFormat: diag,
+
+			LineDirectives: *lineDirectives,
+			Comments:       *comments,
+			TypeCheck:      *typeCheck,
+			LoadTypes:      *loadTypes,
+			SortMaps:       *sortMaps,
+			Safe:           *safe,
+			Recover:        recoverMode,
+			ReturnErrors:   *returnErrors,
+			StickyOutput:   *stickyOutput,
 		}, nil
 	}
 }
 
+// Expands the globs, so the matches are sorted like `ParseGlob` does.
+func expandPaths(list []string) ([]string, error) {
+	paths := make([]string, 0, len(list))
+	for _, s := range list {
+		if len(s) == 0 {
+			continue
+		}
+		if !strings.ContainsAny(s, "*?[") {
+			paths = append(paths, s)
+			continue
+		}
+		matches, err := filepath.Glob(s)
+		if err != nil {
+			return nil, newBadFlag(fmt.Sprintf("bad `in` pattern %q: %v", s, err))
+		}
+		if len(matches) == 0 {
+			return nil, newBadFlag(fmt.Sprintf("`in` pattern matches no files: %q", s))
+		}
+		paths = append(paths, matches...)
+	}
+	if len(paths) == 0 {
+		return nil, newBadFlag("no `in` provided")
+	}
+	return paths, nil
+}
+
 var parseCommandLine = newParser(flag.CommandLine)
 
 func Parse() (*gen.GeneratorOptions, error) {
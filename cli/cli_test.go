@@ -3,6 +3,8 @@ package cli
 import (
 	"flag"
 	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -20,7 +22,7 @@ func TestUsage(t *testing.T) {
 	util.TestEq(
 		t, buf.String(),
 		`Usage of tmtr `+gen.Version+`:
-  tmtr [-pkg name] -fn name -type type -in file [-mode mode] [-out file] [-tpl name[:type] ...] [-import ...] [-tplfn ...]
+  tmtr [-pkg name] -fn name -type type -in file[,...] [-mode mode] [-out file] [-tpl name[:type] ...] [-define name:fn ...] [-import ...] [-tplfn ...] [-diag format] [-line] [-comments] [-typecheck] [-types=false] [-sortmaps=false] [-safe] [-recover mode] [-errors] [-sticky=false]
 
 Examples:
   # Basic usage:
@@ -29,6 +31,9 @@ Examples:
   # External templates:
   tmtr -pkg "main" -fn "RenderIndex" -type "any" -in "./index.html" -tpl "foo:Foo" -tpl "foo:map[string]any"
 
+  # Layout with pages (e.g. "RenderIndexAbout" for "about.html"):
+  tmtr -pkg "main" -fn "RenderIndex" -type "any" -in "./base.html,./pages/*.html" -out "./pages.go"
+
   # User template function and its neccessary import:
   tmtr -pkg "main" -fn "RenderIndex" -type "any" -in "./index.html" -import "strconv" -tplfn "strconv.Atoi"
 
@@ -36,24 +41,46 @@ For more information, see:
   https://github.com/apleshkov/tmtr
 
 Flags:
+  -comments
+    	comment generated statements with their template snippets
+  -define value
+    	[multiple] function name of a defined template or page instead of the generated one, e.g. "nav-bar:RenderNavBar"; comma-separated is also supported
+  -diag string
+    	diagnostics format: 'text' (compiler-style) or 'json' (JSON lines) (default "text")
+  -errors
+    	generate functions returning the first error like Template.Execute does, e.g. 'func RenderIndex(output io.Writer, data T) error', instead of writing the errors to errOutput
   -fn string
     	[required] function name
   -import value
     	[multiple] additional imports, e.g. "net/http"; comma-separated is also supported, e.g. "fmt,strings"
-  -in string
-    	path to the template file
+  -in value
+    	[required] [multiple] template files or globs parsed into one set, e.g. "base.html,pages/*.html"; the first one is the layout, and the ones redefining its templates are pages
+  -line
+    	emit //line directives, so compiler errors and stack traces point at the template
   -mode in
     	'text' or 'html'; optional: 'html' is used if in's extension ends with 'html' (e.g. 'foo.html', 'bar.gohtml'), 'text' otherwise
   -out in
     	path to the output *.go file; optional: adds '.go' to the in filename (e.g. 'foo.html' -> 'foo.html.go')
   -pkg string
     	package name; optional: $GOPACKAGE by default (is set by go:generate)
+  -recover string
+    	recover panics and write them to errOutput: 'func' skips the rest of the generated function, 'action' skips the failed action only
+  -safe
+    	check nil pointers and the index/slice bounds, so failed actions write errors to errOutput instead of panicking
+  -sortmaps
+    	range over maps in the sorted key order like text/template does; disable if the order doesn't matter (default true)
+  -sticky
+    	stop writing at the first failed write (e.g. a disconnected client): the error is written to errOutput once, and the loops exit early (default true)
   -tpl value
     	[multiple] external template with type, e.g. "foo:Foo"; comma-separated is also supported, e.g. "baz:string,quux:[]int"
   -tplfn value
     	[multiple] user template functions; comma-separated is also supported, e.g. "foo,bar"
   -type string
     	[required] data type
+  -typecheck
+    	type-check the generated code against the output file's package before writing it
+  -types
+    	resolve the data types using the output file's package (e.g. to tell fields from methods); falls back to the untyped mode if failed (default true)
   -h, -help
     	Prints this message
 `,
@@ -85,9 +112,75 @@ func TestGood(t *testing.T) {
 	util.TestEq(t, opts.Package, "main")
 	util.TestEq(t, opts.FnName, "Test")
 	util.TestEq(t, opts.DataType, "any")
-	util.TestEq(t, opts.InFile, "./foo.txt")
+	util.TestEqSlice(t, opts.InFiles, []string{"./foo.txt"})
 	util.TestEq(t, opts.OutFile, "./foo.txt.go")
 	util.TestEq(t, opts.Mode, gen.ModeText)
+	util.TestEq(t, opts.DiagFormat, gen.DiagText)
+	util.TestEq(t, opts.LineDirectives, false)
+	util.TestEq(t, opts.Comments, false)
+	util.TestEq(t, opts.TypeCheck, false)
+	util.TestEq(t, opts.LoadTypes, true)
+	util.TestEq(t, opts.SortMaps, true)
+}
+
+func TestLineDirectives(t *testing.T) {
+	opts, _ := newTestParser()(append(testMinArgs, "-line", "-comments"))
+	util.TestEq(t, opts.LineDirectives, true)
+	util.TestEq(t, opts.Comments, true)
+}
+
+func TestTypeCheck(t *testing.T) {
+	opts, _ := newTestParser()(append(testMinArgs, "-typecheck"))
+	util.TestEq(t, opts.TypeCheck, true)
+}
+
+func TestLoadTypes(t *testing.T) {
+	opts, _ := newTestParser()(append(testMinArgs, "-types=false"))
+	util.TestEq(t, opts.LoadTypes, false)
+}
+
+func TestSortMaps(t *testing.T) {
+	opts, _ := newTestParser()(append(testMinArgs, "-sortmaps=false"))
+	util.TestEq(t, opts.SortMaps, false)
+}
+
+func TestSafe(t *testing.T) {
+	opts, _ := newTestParser()(append(testMinArgs, "-safe"))
+	util.TestEq(t, opts.Safe, true)
+}
+
+func TestRecover(t *testing.T) {
+	opts, _ := newTestParser()(testMinArgs)
+	util.TestEq(t, opts.Recover, gen.RecoverNone)
+	opts, _ = newTestParser()(append(testMinArgs, "-recover", "func"))
+	util.TestEq(t, opts.Recover, gen.RecoverFunc)
+	opts, _ = newTestParser()(append(testMinArgs, "-recover", "action"))
+	util.TestEq(t, opts.Recover, gen.RecoverAction)
+	_, err := newTestParser()(append(testMinArgs, "-recover", "all"))
+	util.TestAssert(t, err != nil)
+}
+
+func TestReturnErrors(t *testing.T) {
+	opts, _ := newTestParser()(testMinArgs)
+	util.TestEq(t, opts.ReturnErrors, false)
+	opts, _ = newTestParser()(append(testMinArgs, "-errors"))
+	util.TestEq(t, opts.ReturnErrors, true)
+}
+
+func TestStickyOutput(t *testing.T) {
+	opts, _ := newTestParser()(testMinArgs)
+	util.TestEq(t, opts.StickyOutput, true)
+	opts, _ = newTestParser()(append(testMinArgs, "-sticky=false"))
+	util.TestEq(t, opts.StickyOutput, false)
+}
+
+func TestDiag(t *testing.T) {
+	opts, _ := newTestParser()(append(testMinArgs, "-diag", "json"))
+	util.TestEq(t, opts.DiagFormat, gen.DiagJSON)
+	opts, _ = newTestParser()(append(testMinArgs, "-diag", "text"))
+	util.TestEq(t, opts.DiagFormat, gen.DiagText)
+	_, err := newTestParser()(append(testMinArgs, "-diag", "xml"))
+	util.TestAssert(t, err != nil)
 }
 
 func TestMode(t *testing.T) {
@@ -95,12 +188,35 @@ func TestMode(t *testing.T) {
 	util.TestEq(t, opts.Mode, gen.ModeText)
 	opts, _ = newTestParser()(append(testMinArgs, "-mode", "html"))
 	util.TestEq(t, opts.Mode, gen.ModeHTML)
-	opts, _ = newTestParser()(append(testMinArgs, "-in", "test.html"))
+	opts, _ = newTestParser()(append(testBaseArgs, "-in", "test.html"))
+	util.TestEq(t, opts.Mode, gen.ModeHTML)
+	opts, _ = newTestParser()(append(testBaseArgs, "-in", "/path/to/test.gohtml"))
 	util.TestEq(t, opts.Mode, gen.ModeHTML)
-	opts, _ = newTestParser()(append(testMinArgs, "-in", "/path/to/test.gohtml"))
+	opts, _ = newTestParser()(append(testBaseArgs, "-in", "base.html,foo.txt"))
 	util.TestEq(t, opts.Mode, gen.ModeHTML)
 }
 
+func TestIn(t *testing.T) {
+	opts, _ := newTestParser()(append(testBaseArgs, "-in", "base.html, a.html", "-in", "b.html"))
+	util.TestEqSlice(t, opts.InFiles, []string{"base.html", "a.html", "b.html"})
+	util.TestEq(t, opts.OutFile, "base.html.go")
+	dir := t.TempDir()
+	for _, name := range []string{"base.html", "b.html", "a.html", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	base := filepath.Join(dir, "base.html")
+	opts, _ = newTestParser()(append(testBaseArgs, "-in", base+","+filepath.Join(dir, "?.html")))
+	util.TestEqSlice(t, opts.InFiles, []string{
+		base,
+		filepath.Join(dir, "a.html"),
+		filepath.Join(dir, "b.html"),
+	})
+	_, err := newTestParser()(append(testBaseArgs, "-in", filepath.Join(dir, "*.go")))
+	util.TestAssert(t, err != nil)
+}
+
 func TestTmpls(t *testing.T) {
 	opts, _ := newTestParser()(append(testMinArgs, "-tpl", "foo:any"))
 	util.TestEqSlice(t, opts.Tmpls, []gen.NamedTemplateInfo{
@@ -128,6 +244,20 @@ func TestTmpls(t *testing.T) {
 	})
 }
 
+func TestDefines(t *testing.T) {
+	opts, _ := newTestParser()(testMinArgs)
+	util.TestEq(t, opts.FnNames == nil, true)
+	opts, _ = newTestParser()(append(testMinArgs, "-define", "nav-bar: RenderNav, about.html:renderAbout", "-define", "1col:Render1"))
+	util.TestEq(t, len(opts.FnNames), 3)
+	util.TestEq(t, opts.FnNames["nav-bar"], "RenderNav")
+	util.TestEq(t, opts.FnNames["about.html"], "renderAbout")
+	util.TestEq(t, opts.FnNames["1col"], "Render1")
+	_, err := newTestParser()(append(testMinArgs, "-define", "foo"))
+	util.TestAssert(t, err != nil)
+	_, err = newTestParser()(append(testMinArgs, "-define", "foo:Render-Foo"))
+	util.TestAssert(t, err != nil)
+}
+
 func TestImports(t *testing.T) {
 	opts, _ := newTestParser()(append(testMinArgs, "-import", "net"))
 	util.TestEqSlice(t, opts.Imports, []string{"net"})
@@ -152,6 +282,13 @@ func newTestParser() parseFn {
 	return newParser(fs)
 }
 
+// Without `-in`
+var testBaseArgs = []string{
+	"-pkg", "main",
+	"-fn", "Test",
+	"-type", "any",
+}
+
 var testMinArgs = []string{
 	"-pkg", "main",
 	"-fn", "Test",
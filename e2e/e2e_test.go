@@ -5,7 +5,10 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
 	"strings"
 	"testing"
 
@@ -138,6 +141,933 @@ func TestCallMethod(t *testing.T) {
 	)
 }
 
+func TestTypedMethods(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`{{.Title}} {{.GetTitle}} {{.Prefixed "x"}} {{.Item.Upper}} {{.Key}}{{range .Items}} {{.Upper}}{{end}} {{.N.Upper}} {{.Load}}`,
+			gen.GeneratorOptions{
+				Mode:      gen.ModeHTML,
+				DataType:  "*data",
+				FnName:    "render",
+				LoadTypes: true,
+			},
+			[]file{
+				newBasicMainFile("render", `&data{Base: &Base{"k"}, Title: "t", Items: []Item{{"a"}, {"b"}}, N: Item{"n"}}`),
+				{
+					name: "data.go",
+					content: `package main
+import "strings"
+type Upper interface{ Upper() string }
+type Base struct{ key string }
+func (b *Base) Key() string { return b.key }
+type Item struct{ Title string }
+func (i Item) Upper() string { return strings.ToUpper(i.Title) }
+type data struct {
+	*Base
+	Title string
+	Items []Item
+	N     Upper
+}
+func (d *data) GetTitle() string { return d.Title + "!" }
+func (d data) Prefixed(s string) string { return s + d.Title }
+func (d data) Item() Item { return Item{"i"} }
+func (d data) Load() (string, error) { return "l", nil }
+`,
+				},
+			},
+		),
+		"t t! xt I k A B N l",
+	)
+}
+
+func TestDefinedTemplates(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`{{define "tree"}}{{.Name}}{{if .Children}}({{range $i, $c := .Children}}{{if $i}} {{end}}{{template "tree" $c}}{{end}}){{end}}{{end}}{{template "tree" .}}`,
+			gen.GeneratorOptions{
+				Mode:      gen.ModeText,
+				DataType:  "*node",
+				FnName:    "render",
+				LoadTypes: true,
+			},
+			[]file{
+				newBasicMainFile("render", `&node{"a", []*node{{"b", nil}, {"c", []*node{{"d", nil}}}}}`),
+				{
+					name: "data.go",
+					content: `package main
+type node struct {
+	Name     string
+	Children []*node
+}
+`,
+				},
+			},
+		),
+		"a(b c(d))",
+	)
+}
+
+func TestBlocks(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`<title>{{block "title" .}}{{.}}{{end}}</title><main>{{block "content" .}}<p>{{.}}</p>{{end}}</main>|`,
+			gen.GeneratorOptions{
+				Mode:     gen.ModeHTML,
+				DataType: "string",
+				FnName:   "render",
+			},
+			[]file{
+				newMainFile(`package main
+import (
+	"io"
+	"os"
+)
+func main() {
+	render(os.Stdout, "a<b", nil, nil, os.Stdout)
+	render(os.Stdout, "c", func(w io.Writer, s string, _ io.Writer) { io.WriteString(w, "<ul>"+s+"</ul>") }, nil, os.Stdout)
+}
+`),
+			},
+		),
+		"<title>a&lt;b</title><main><p>a&lt;b</p></main>|<title>c</title><main><ul>c</ul></main>|",
+	)
+}
+
+func TestPages(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			"",
+			gen.GeneratorOptions{
+				Mode:     gen.ModeHTML,
+				DataType: "string",
+				FnName:   "render",
+				InFiles:  []string{"base.html", "pages/*.html"},
+			},
+			[]file{
+				newMainFile(`package main
+import "os"
+func main() {
+	render(os.Stdout, "a<b", nil, nil, os.Stdout)
+	renderAbout(os.Stdout, "c", os.Stdout)
+	renderHome(os.Stdout, "d", os.Stdout)
+}
+`),
+				{
+					name:    "base.html",
+					content: `<title>{{block "title" .}}{{.}}{{end}}</title><main>{{block "content" .}}<p>{{.}}</p>{{end}}</main>|`,
+				},
+				{
+					name:    "pages/about.html",
+					content: `{{define "content"}}<a href="/{{.}}">{{.}}</a>{{end}}`,
+				},
+				{
+					name:    "pages/home.html",
+					content: `{{define "title"}}Home{{end}}`,
+				},
+			},
+		),
+		`<title>a&lt;b</title><main><p>a&lt;b</p></main>|<title>c</title><main><a href="/c">c</a></main>|<title>Home</title><main><p>d</p></main>|`,
+	)
+}
+
+func TestContextVariants(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`{{define "v"}}{{.}}{{end}}<p>{{template "v" .}}</p><a title="{{template "v" .}}"><textarea>{{template "v" .}}</textarea>`,
+			gen.GeneratorOptions{
+				Mode:     gen.ModeHTML,
+				DataType: "string",
+				FnName:   "render",
+			},
+			[]file{
+				newBasicMainFile("render", "`a\"b <c>`"),
+			},
+		),
+		// the same as html/template's
+		`<p>a&#34;b &lt;c&gt;</p><a title="a&#34;b &lt;c&gt;"><textarea>a&#34;b &lt;c&gt;</textarea>`,
+	)
+}
+
+func TestVarNames(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`{{$type := "a"}}{{$output := "b"}}{{range $len, $io := .}}{{$tmtr := $io}}{{$type}}{{$output}}{{$len}}{{$tmtr}}{{end}}`,
+			gen.GeneratorOptions{
+				Mode:     gen.ModeText,
+				DataType: "[]string",
+				FnName:   "render",
+			},
+			[]file{
+				newBasicMainFile("render", `[]string{"x", "y"}`),
+			},
+		),
+		"ab0xab1y",
+	)
+}
+
+func TestUnusedVars(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`{{$x := 1}}{{range $i, $v := .}}{{$i}}{{end}}{{range $i, $v := .}}{{end}}{{range .}}.{{end}}{{if .}}{{$x = 2}}{{end}}`,
+			gen.GeneratorOptions{
+				Mode:     gen.ModeText,
+				DataType: "[]string",
+				FnName:   "render",
+				Imports:  []string{"strings"},
+			},
+			[]file{
+				newBasicMainFile("render", `[]string{"x", "y"}`),
+			},
+		),
+		"01..",
+	)
+}
+
+func TestReassignedVars(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`{{$x := 0}}{{if .Flag}}{{$x = .Name}}{{end}}{{$x}} {{$c := false}}{{range .Items}}{{$c = .}}{{end}}{{$c}} {{$f := 1}}{{$f = 2.5}}{{$f}}`,
+			gen.GeneratorOptions{
+				Mode:      gen.ModeText,
+				DataType:  "data",
+				FnName:    "render",
+				LoadTypes: true,
+			},
+			[]file{
+				newBasicMainFile("render", `data{Flag: true, Name: "n", Items: []string{"a", "b"}}`),
+				{
+					name: "data.go",
+					content: `package main
+type data struct {
+	Flag  bool
+	Name  string
+	Items []string
+}
+`,
+				},
+			},
+		),
+		"n b 2.5",
+	)
+}
+
+func TestRangeKinds(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`{{range 3}}{{.}}{{end}}|{{range $i, $v := .Ch}}{{$i}}{{$v}}{{end}}|{{range .Empty}}x{{else}}none{{end}}|`+
+				`{{range $k, $v := .Seq2}}{{if eq $k 2}}{{break}}{{end}}{{$v}}{{end}}|{{range .Seq}}{{if eq . "a"}}{{continue}}{{end}}{{.}}{{end}}|`+
+				`{{range .Nil}}x{{else}}nil{{end}}`,
+			gen.GeneratorOptions{
+				Mode:      gen.ModeText,
+				DataType:  "data",
+				FnName:    "render",
+				LoadTypes: true,
+			},
+			[]file{
+				newBasicMainFile("render", `newData()`),
+				{
+					name: "data.go",
+					content: `package main
+import "iter"
+type data struct {
+	Ch, Empty, Nil chan string
+	Seq            iter.Seq[string]
+	Seq2           iter.Seq2[int, string]
+}
+func newData() data {
+	d := data{
+		Ch:    make(chan string, 2),
+		Empty: make(chan string),
+		Seq: func(yield func(string) bool) {
+			_ = yield("a") && yield("b") && yield("c")
+		},
+		Seq2: func(yield func(int, string) bool) {
+			_ = yield(1, "x") && yield(2, "y")
+		},
+	}
+	d.Ch <- "a"
+	d.Ch <- "b"
+	close(d.Ch)
+	close(d.Empty)
+	return d
+}
+`,
+				},
+			},
+		),
+		"012|0a1b|none|x|bc|nil",
+	)
+}
+
+func TestDynamicRangeKinds(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`{{range .Ch}}x{{else}}empty-ch{{end}}|{{range .Seq}}x{{else}}empty-seq{{end}}|{{range .Items}}{{.}}{{else}}none{{end}}|`+
+				`{{range .Seq2}}{{.}},{{end}}|{{range $v := .Seq2}}{{$v}},{{end}}|{{range $k, $v := .Seq2}}{{$k}}={{$v}},{{end}}`,
+			gen.GeneratorOptions{
+				Mode:      gen.ModeText,
+				DataType:  "data",
+				FnName:    "render",
+				LoadTypes: true,
+			},
+			[]file{
+				newBasicMainFile("render", `newData()`),
+				{
+					name: "data.go",
+					content: `package main
+import "iter"
+type data struct {
+	Ch, Seq, Seq2, Items any
+}
+func newData() data {
+	ch := make(chan string)
+	close(ch)
+	return data{
+		Ch:    ch,
+		Seq:   iter.Seq[string](func(yield func(string) bool) {}),
+		Seq2: iter.Seq2[string, int](func(yield func(string, int) bool) {
+			_ = yield("k1", 1) && yield("k2", 2)
+		}),
+		Items: []int{1, 2},
+	}
+}
+`,
+				},
+			},
+		),
+		"empty-ch|empty-seq|12|k1,k2,|k1,k2,|k1=1,k2=2,",
+	)
+}
+
+func TestDollar(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`{{range .Items}}{{.}}{{$.Title}}{{with .}}{{$.Title}}{{end}}{{end}}`,
+			gen.GeneratorOptions{
+				Mode:      gen.ModeText,
+				DataType:  "data",
+				FnName:    "render",
+				LoadTypes: true,
+			},
+			[]file{
+				newBasicMainFile("render", `data{Title: "t", Items: []string{"a", "b"}}`),
+				{
+					name: "data.go",
+					content: `package main
+type data struct {
+	Title string
+	Items []string
+}
+`,
+				},
+			},
+		),
+		"attbtt",
+	)
+}
+
+func TestVersionedImports(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	opts := gen.GeneratorOptions{
+		Mode:      gen.ModeText,
+		DataType:  "int",
+		FnName:    "render",
+		Imports:   []string{"math/rand/v2"},
+		LoadTypes: true,
+	}
+	files := []file{newBasicMainFile("render", "1")}
+	util.TestEq(t, generate(`{{rand.IntN .}}`, opts, files), "0")
+	opts.LoadTypes = false
+	util.TestEq(t, generate(`{{rand.IntN .}}`, opts, files), "0")
+}
+
+func TestSortedMaps(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`{{range $k, $v := .}}{{$k}}={{$v}};{{end}}`,
+			gen.GeneratorOptions{
+				Mode:      gen.ModeText,
+				DataType:  "map[string]int",
+				FnName:    "render",
+				LoadTypes: true,
+				SortMaps:  true,
+			},
+			[]file{
+				newBasicMainFile("render", `map[string]int{"c": 3, "a": 1, "d": 4, "b": 2, "e": 5}`),
+			},
+		),
+		"a=1;b=2;c=3;d=4;e=5;",
+	)
+	util.TestEq(
+		t,
+		generate(
+			`{{range $k, $v := .}}{{$k}}={{$v}};{{end}}`,
+			gen.GeneratorOptions{
+				Mode:      gen.ModeText,
+				DataType:  "map[bool]int",
+				FnName:    "render",
+				LoadTypes: true,
+				SortMaps:  true,
+			},
+			[]file{
+				newBasicMainFile("render", `map[bool]int{true: 1, false: 0}`),
+			},
+		),
+		"false=0;true=1;",
+	)
+}
+
+func TestShortCircuit(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`{{if and .User .User.Admin}}admin{{else}}guest{{end}} {{or .Nick .Name}} {{.Name | or .Nick}} {{and .Flag (not .Flag)}} {{or 0 "" 3}}`,
+			gen.GeneratorOptions{
+				Mode:      gen.ModeText,
+				DataType:  "data",
+				FnName:    "render",
+				LoadTypes: true,
+			},
+			[]file{
+				newBasicMainFile("render", `data{Name: "n", Flag: true}`),
+				{
+					name: "data.go",
+					content: `package main
+type user struct {
+	Admin bool
+}
+type data struct {
+	User       *user
+	Nick, Name string
+	Flag       bool
+}
+`,
+				},
+			},
+		),
+		"guest n n false 3",
+	)
+}
+
+func TestComparison(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`{{eq .Count 0}} {{eq .A .B}} {{lt .A .B}} {{eq .Any 2}} {{eq .Any 1 2 3}} {{ge .B -1}} {{ne .Name "n"}}`,
+			gen.GeneratorOptions{
+				Mode:      gen.ModeText,
+				DataType:  "data",
+				FnName:    "render",
+				LoadTypes: true,
+			},
+			[]file{
+				newBasicMainFile("render", `data{Count: 0, A: 2, B: 2, Any: int64(2), Name: "n"}`),
+				{
+					name: "data.go",
+					content: `package main
+type data struct {
+	Count int64
+	A     int
+	B     uint8
+	Any   any
+	Name  string
+}
+`,
+				},
+			},
+		),
+		"true true false true true true false",
+	)
+}
+
+func TestSafe(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`{{range .Users}}[{{.Profile.Name}}]{{end}} [{{index .Tags 5}}] [{{slice .Tags 1 9}}] {{$n := .Nil.Profile}}[{{$n}}] {{if or .Nil .Nil.Profile}}x{{else}}ok{{end}}`,
+			gen.GeneratorOptions{
+				Mode:      gen.ModeText,
+				DataType:  "data",
+				FnName:    "render",
+				LoadTypes: true,
+				Safe:      true,
+			},
+			[]file{
+				newBasicMainFile("render", `data{Users: []*user{{Profile: &profile{"a"}}, {}}, Tags: []string{"x"}}`),
+				{
+					name: "data.go",
+					content: `package main
+type profile struct {
+	Name string
+}
+type user struct {
+	Profile *profile
+}
+type data struct {
+	Users []*user
+	Tags  []string
+	Nil   *user
+}
+`,
+				},
+			},
+		),
+		"[a][input.text:1:28: nil pointer evaluating *profile.Name\n] [input.text:1:47: error calling index: index out of range: 5\n] [input.text:1:67: error calling slice: index out of range: 9\n] input.text:1:98: nil pointer evaluating *user.Profile\n[<nil>] input.text:1:134: nil pointer evaluating *user.Profile\nok",
+	)
+}
+
+func TestRecover(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	files := []file{
+		newBasicMainFile("render", `data{Names: map[string]int{"a": 1}}`),
+		{
+			name: "data.go",
+			content: `package main
+type data struct {
+	Names map[string]int
+}
+func (d data) Fail(s string) string {
+	panic("failed " + s)
+}
+`,
+		},
+	}
+	opts := gen.GeneratorOptions{
+		Mode:      gen.ModeText,
+		DataType:  "data",
+		FnName:    "render",
+		LoadTypes: true,
+		Recover:   gen.RecoverFunc,
+	}
+	tmpl := `a [{{.Fail "x"}}] {{index .Names "a"}} [{{.Fail "y"}}] b`
+	util.TestEq(t, generate(tmpl, opts, files), "a [input.text:1:6: panic: failed x\n")
+	opts.Recover = gen.RecoverAction
+	util.TestEq(
+		t,
+		generate(tmpl, opts, files),
+		"a [input.text:1:6: panic: failed x\n] 1 [input.text:1:43: panic: failed y\n] b",
+	)
+}
+
+func TestReturnErrors(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	files := []file{
+		newMainFile(`package main
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+type failingWriter struct{}
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("closed")
+}
+func main() {
+	fmt.Println("|", render(os.Stdout, data{}))
+	fmt.Println("|", render(failingWriter{}, data{}))
+	fmt.Println("|", render(os.Stdout, data{Ok: true}))
+}
+`),
+		{
+			name: "data.go",
+			content: `package main
+import "errors"
+type data struct {
+	Ok bool
+}
+func (d data) Title() (string, error) {
+	if d.Ok {
+		return "T", nil
+	}
+	return "", errors.New("no title")
+}
+`,
+		},
+	}
+	opts := gen.GeneratorOptions{
+		Mode:         gen.ModeText,
+		DataType:     "data",
+		FnName:       "render",
+		LoadTypes:    true,
+		ReturnErrors: true,
+	}
+	util.TestEq(
+		t,
+		generate(`a{{template "title" .}}b{{define "title"}}[{{.Title}}]{{end}}`, opts, files),
+		"a[| no title\n| closed\na[T]b| <nil>\n",
+	)
+}
+
+func TestStickyOutput(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	files := []file{
+		newMainFile(`package main
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+type failingWriter struct {
+	n int
+}
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.n++
+	return 0, errors.New("broken pipe")
+}
+func main() {
+	w := &failingWriter{}
+	var ew strings.Builder
+	render(w, []int{1, 2, 3}, &ew)
+	fmt.Print(w.n, " ", ew.String())
+	render(os.Stdout, []int{1, 2, 3}, os.Stdout)
+}
+`),
+	}
+	opts := gen.GeneratorOptions{
+		Mode:         gen.ModeText,
+		DataType:     "[]int",
+		FnName:       "render",
+		LoadTypes:    true,
+		StickyOutput: true,
+	}
+	tmpl := `{{define "sep"}},{{end}}{{range .}}{{.}}{{template "sep" $}}{{end}}`
+	util.TestEq(t, generate(tmpl, opts, files), "1 broken pipe\n1,2,3,")
+	opts.StickyOutput = false
+	util.TestEq(t, generate(tmpl, opts, files), "6 "+strings.Repeat("broken pipe\n", 6)+"1,2,3,")
+}
+
+// The benchmarks measure the generated code, so it must be up to date (run
+// `go generate` in ./bench otherwise).
+func TestBenchGenerated(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	dir := "benchgenerated"
+	must(os.MkdirAll(dir, os.ModePerm))
+	defer func() {
+		must(os.RemoveAll(dir))
+	}()
+	entries := mustx(os.ReadDir("../bench"))
+	for _, e := range entries {
+		src := mustx(os.ReadFile(path.Join("../bench", e.Name())))
+		if e.Name() == "go.mod" {
+			src = []byte(strings.ReplaceAll(string(src), "=> ../funcs", "=> ../../funcs"))
+		}
+		must(os.WriteFile(path.Join(dir, e.Name()), src, 0666))
+	}
+	bin := mustx(filepath.Abs("."))
+	cmd := exec.Command("go", "generate", "./...")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	runCommand(cmd)
+	for _, e := range entries {
+		if name := e.Name(); strings.HasSuffix(name, ".go") {
+			util.TestEq(
+				t,
+				string(mustx(os.ReadFile(path.Join(dir, name)))),
+				string(mustx(os.ReadFile(path.Join("../bench", name)))),
+			)
+		}
+	}
+}
+
+func TestTemplateNames(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`{{define "nav-bar"}}<{{.}}>{{end}}{{define "1col"}}[{{.}}]{{end}}{{template "nav-bar" .}}{{template "1col" .}}`,
+			gen.GeneratorOptions{
+				Mode:     gen.ModeText,
+				DataType: "string",
+				FnName:   "render",
+				FnNames:  map[string]string{"1col": "renderCol"},
+			},
+			[]file{
+				newMainFile(`package main
+import "os"
+func main() {
+	render(os.Stdout, "a", os.Stdout)
+	renderNavBar(os.Stdout, "b", os.Stdout)
+	renderCol(os.Stdout, "c", os.Stdout)
+}
+`),
+			},
+		),
+		"<a>[a]<b>[c]",
+	)
+}
+
+func TestTypedWrites(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`<p title="{{.Title}}">{{.Title}} {{.Count}} {{.OK}} {{.Body}} {{.Status}}</p><a href="/?q={{.Title}}&n={{.Count}}">`,
+			gen.GeneratorOptions{
+				Mode:      gen.ModeHTML,
+				DataType:  "data",
+				FnName:    "render",
+				LoadTypes: true,
+			},
+			[]file{
+				newBasicMainFile("render", `data{"a<b> & c", -5, true, "<i>x</i>", 1}`),
+				{
+					name: "data.go",
+					content: `package main
+import "html/template"
+type Status int
+func (s Status) String() string { return "<ok>" }
+type data struct {
+	Title  string
+	Count  int
+	OK     bool
+	Body   template.HTML
+	Status Status
+}
+`,
+				},
+			},
+		),
+		`<p title="a&lt;b&gt; &amp; c">a&lt;b&gt; &amp; c -5 true <i>x</i> &lt;ok&gt;</p><a href="/?q=a%3Cb%3E+%26+c&n=-5">`,
+	)
+}
+
+func TestTypedMaps(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`{{.Name}} {{.Missing}}`,
+			gen.GeneratorOptions{
+				Mode:      gen.ModeText,
+				DataType:  "map[string]int",
+				FnName:    "render",
+				LoadTypes: true,
+			},
+			[]file{
+				newBasicMainFile("render", `map[string]int{"Name": 1}`),
+			},
+		),
+		"1 0",
+	)
+	util.TestEq(
+		t,
+		generate(
+			`{{range .Users}}{{.Name}}{{.Age}} {{end}}`,
+			gen.GeneratorOptions{
+				Mode:      gen.ModeText,
+				DataType:  "data",
+				FnName:    "render",
+				LoadTypes: true,
+			},
+			[]file{
+				newBasicMainFile("render", `data{Users: []map[string]string{{"Name": "a", "Age": "1"}, {"Name": "b"}}}`),
+				{
+					name:    "data.go",
+					content: "package main\ntype data struct{ Users []map[string]string }\n",
+				},
+			},
+		),
+		"a1 b ",
+	)
+}
+
+func TestDynamic(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	util.TestEq(
+		t,
+		generate(
+			`{{.user.Name}} {{.user.Greet "hi"}} {{index .tags 1}} {{len .tags}}{{range $k, $v := .scores}} {{$k}}={{$v}}{{end}}{{range .tags}}{{if eq . "b"}}{{break}}{{end}} {{.}}{{end}}{{range .none}}{{else}} none{{end}} [{{.missing}}]`,
+			gen.GeneratorOptions{
+				Mode:      gen.ModeText,
+				DataType:  "any",
+				FnName:    "render",
+				LoadTypes: true,
+			},
+			[]file{
+				newBasicMainFile("render", `map[string]any{
+	"user": &user{"u"},
+	"tags": []string{"a", "b", "c"},
+	"scores": map[string]int{"z": 1, "x": 2, "y": 3},
+}`),
+				{
+					name: "data.go",
+					content: `package main
+type user struct{ Name string }
+func (u *user) Greet(s string) string { return s + " " + u.Name }
+`,
+				},
+			},
+		),
+		"u hi u b 3 x=2 y=3 z=1 a none [<nil>]",
+	)
+}
+
+func TestEscapingError(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	stderr, err := generateFailure(
+		"<p>\n<a {{if .}}href{{end}}=\"x\">",
+		gen.GeneratorOptions{
+			Mode:     gen.ModeHTML,
+			DataType: "string",
+			FnName:   "render",
+		},
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	util.TestAssert(t, strings.Contains(stderr, "input.html:2:9: error: {{if}} branches end in different contexts"))
+}
+
+func TestLineDirectives(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	opts := gen.GeneratorOptions{
+		Mode:           gen.ModeHTML,
+		DataType:       "data",
+		FnName:         "render",
+		LineDirectives: true,
+		Comments:       true,
+	}
+	files := []file{
+		newBasicMainFile("render", "data{}"),
+		{
+			name:    "data.go",
+			content: "package main\ntype data struct { Name string }",
+		},
+	}
+	// compiling
+	_, stderr, err := generateAndRun("<p>\n  {{.Nmae}}</p>", opts, files, 1)
+	util.TestAssert(t, err != nil)
+	util.TestAssert(t, regexp.MustCompile(`input.html:2:\d+: data.Nmae undefined`).MatchString(stderr))
+	// stack traces
+	_, stderr, err = generateAndRun("<p>\n\n  {{index .Name 10}}</p>", opts, files, 1)
+	util.TestAssert(t, err != nil)
+	util.TestAssert(t, strings.Contains(stderr, "input.html:3"))
+}
+
+func TestTypeCheck(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	opts := gen.GeneratorOptions{
+		Mode:      gen.ModeHTML,
+		DataType:  "data",
+		FnName:    "render",
+		TypeCheck: true,
+	}
+	files := []file{
+		newBasicMainFile("render", "data{}"),
+		{
+			name:    "data.go",
+			content: "package main\ntype data struct { Title string }",
+		},
+	}
+	stderr, err := generateFailure("<h1>\n  {{.Titel}}</h1>{{.Title.Foo}}", opts, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	util.TestEq(t, stderr, strings.Join([]string{
+		"input.html:2:5: error: data.Titel undefined (type data has no field or method Titel)",
+		"input.html:2:20: error: data.Title.Foo undefined (type string has no field or method Foo)",
+		"",
+	}, "\n"))
+	// no directives are written unless asked
+	util.TestEq(
+		t,
+		generate("<h1>{{.Title}}</h1>", opts, files),
+		"<h1></h1>",
+	)
+	// the loaded types report the fields before the type checking
+	opts.LoadTypes = true
+	opts.SortMaps = true
+	opts.StickyOutput = true
+	stderr, err = generateFailure("<h1>\n  {{.Titel}}</h1>", opts, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	util.TestEq(t, stderr, "input.html:2:5: error: can't evaluate field Titel in type data\n")
+}
+
 type file struct {
 	name, content string
 }
@@ -181,8 +1111,18 @@ func mustx[T any](v T, err error) T {
 }
 
 func generate(tmpl string, opts gen.GeneratorOptions, files []file) string {
+	stdout, stderr, err := generateAndRun(tmpl, opts, files, 2)
+	if err != nil {
+		panic(stderr)
+	}
+	return stdout
+}
+
+// Generates and runs the code, returning its stdout and stderr. `depth`
+// is used to name a temporary directory after the test.
+func generateAndRun(tmpl string, opts gen.GeneratorOptions, files []file, depth int) (string, string, error) {
 	var testName string
-	if pc, _, _, ok := runtime.Caller(1); ok {
+	if pc, _, _, ok := runtime.Caller(depth); ok {
 		n := runtime.FuncForPC(pc).Name()
 		if i := strings.LastIndex(n, "."); i != -1 {
 			n := n[(i + 1):]
@@ -197,56 +1137,142 @@ func generate(tmpl string, opts gen.GeneratorOptions, files []file) string {
 	defer func() {
 		must(os.RemoveAll(tmpdir))
 	}()
-	var modeStr string
-	switch opts.Mode {
-	case gen.ModeHTML:
-		modeStr = "html"
-	case gen.ModeText:
-		modeStr = "text"
-	default:
-		panic(fmt.Sprintf("invalid mode: %v", opts.Mode))
-	}
-	inFile := mustx(createInputFile(tmpdir, tmpl, modeStr))
+	writeModule(tmpdir, testName, files)
+	modeStr := modeString(opts.Mode)
+	inFiles := inputFiles(tmpdir, tmpl, opts)
 	outFile := path.Join(tmpdir, "render.go")
 	runCommandFunc(func() *exec.Cmd {
-		args := []string{
-			"-pkg", "main",
-			"-mode", modeStr,
-			"-fn", opts.FnName,
-			"-type", opts.DataType,
-			"-in", inFile,
-			"-out", outFile,
-		}
-		for _, v := range opts.Tmpls {
-			if len(v.DataType) > 0 {
-				args = append(args, "-tpl", v.Name+":"+v.DataType)
-			} else {
-				args = append(args, "-tpl", v.Name)
-			}
-		}
-		for _, v := range opts.Imports {
-			args = append(args, "-import", v)
-		}
-		for _, v := range opts.Funcs {
-			args = append(args, "-tplfn", v)
-		}
-		cmd := exec.Command("./tmtr", args...)
-		return cmd
+		return exec.Command("./tmtr", tmtrArgs(opts, modeStr, inFiles, outFile)...)
 	})
+	var out, ew strings.Builder
+	cmd := exec.Command("go", "run", "-C", tmpdir, ".")
+	cmd.Stdout = &out
+	cmd.Stderr = &ew
+	err := cmd.Run()
+	return out.String(), ew.String(), err
+}
+
+// Writes the files and go.mod requiring the local funcs module
+func writeModule(dir, name string, files []file) {
 	for _, f := range files {
-		must(os.WriteFile(path.Join(tmpdir, f.name), []byte(f.content), os.ModePerm))
+		p := path.Join(dir, f.name)
+		must(os.MkdirAll(path.Dir(p), os.ModePerm))
+		must(os.WriteFile(p, []byte(f.content), os.ModePerm))
 	}
 	ver := strings.Replace(runtime.Version(), "go", "go ", 1)
 	funcsdep := fmt.Sprintf("%s v0.0.0-unpublished", gen.FuncsPkgPath)
-	gomod := fmt.Sprintf("module %s\n%s\nrequire %s\nreplace %s => ../../funcs", testName, ver, funcsdep, funcsdep)
-	must(os.WriteFile(path.Join(tmpdir, "go.mod"), []byte(gomod), os.ModePerm))
-	var buf strings.Builder
-	runCommandFunc(func() *exec.Cmd {
-		cmd := exec.Command("go", "run", "-C", tmpdir, ".")
-		cmd.Stdout = &buf
-		return cmd
-	})
-	return buf.String()
+	gomod := fmt.Sprintf("module %s\n%s\nrequire %s\nreplace %s => ../../funcs", name, ver, funcsdep, funcsdep)
+	must(os.WriteFile(path.Join(dir, "go.mod"), []byte(gomod), os.ModePerm))
+}
+
+// Runs the generator expecting it to fail, and returns its stderr
+func generateFailure(tmpl string, opts gen.GeneratorOptions, files []file) (string, error) {
+	tmpdir, err := os.MkdirTemp(".", "failure")
+	must(err)
+	defer func() {
+		must(os.RemoveAll(tmpdir))
+	}()
+	writeModule(tmpdir, "failure", files)
+	modeStr := modeString(opts.Mode)
+	inFiles := inputFiles(tmpdir, tmpl, opts)
+	outFile := path.Join(tmpdir, "render.go")
+	var ew strings.Builder
+	cmd := exec.Command("./tmtr", tmtrArgs(opts, modeStr, inFiles, outFile)...)
+	cmd.Stderr = &ew
+	if err := cmd.Run(); err == nil {
+		return "", fmt.Errorf("the generator has succeeded")
+	}
+	if _, err := os.Stat(outFile); !os.IsNotExist(err) {
+		return "", fmt.Errorf("the output file exists")
+	}
+	return ew.String(), nil
+}
+
+func modeString(mode gen.Mode) string {
+	switch mode {
+	case gen.ModeHTML:
+		return "html"
+	case gen.ModeText:
+		return "text"
+	default:
+		panic(fmt.Sprintf("invalid mode: %v", mode))
+	}
+}
+
+func tmtrArgs(opts gen.GeneratorOptions, modeStr string, inFiles []string, outFile string) []string {
+	args := []string{
+		"-pkg", "main",
+		"-mode", modeStr,
+		"-fn", opts.FnName,
+		"-type", opts.DataType,
+		"-in", strings.Join(inFiles, ","),
+		"-out", outFile,
+	}
+	for _, v := range opts.Tmpls {
+		if len(v.DataType) > 0 {
+			args = append(args, "-tpl", v.Name+":"+v.DataType)
+		} else {
+			args = append(args, "-tpl", v.Name)
+		}
+	}
+	names := make([]string, 0, len(opts.FnNames))
+	for n := range opts.FnNames {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+	for _, n := range names {
+		args = append(args, "-define", n+":"+opts.FnNames[n])
+	}
+	for _, v := range opts.Imports {
+		args = append(args, "-import", v)
+	}
+	for _, v := range opts.Funcs {
+		args = append(args, "-tplfn", v)
+	}
+	if opts.LineDirectives {
+		args = append(args, "-line")
+	}
+	if opts.Comments {
+		args = append(args, "-comments")
+	}
+	if opts.TypeCheck {
+		args = append(args, "-typecheck")
+	}
+	if !opts.LoadTypes {
+		args = append(args, "-types=false")
+	}
+	if !opts.SortMaps {
+		args = append(args, "-sortmaps=false")
+	}
+	if opts.Safe {
+		args = append(args, "-safe")
+	}
+	switch opts.Recover {
+	case gen.RecoverFunc:
+		args = append(args, "-recover", "func")
+	case gen.RecoverAction:
+		args = append(args, "-recover", "action")
+	}
+	if opts.ReturnErrors {
+		args = append(args, "-errors")
+	}
+	if !opts.StickyOutput {
+		args = append(args, "-sticky=false")
+	}
+	return args
+}
+
+// Returns `opts.InFiles` (they're written along with the other files) if
+// any, or creates the input file with the template.
+func inputFiles(dir, tmpl string, opts gen.GeneratorOptions) []string {
+	if len(opts.InFiles) == 0 {
+		return []string{mustx(createInputFile(dir, tmpl, modeString(opts.Mode)))}
+	}
+	paths := make([]string, len(opts.InFiles))
+	for i, s := range opts.InFiles {
+		paths[i] = path.Join(dir, s)
+	}
+	return paths
 }
 
 func createInputFile(dir, src string, ext string) (string, error) {
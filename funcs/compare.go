@@ -0,0 +1,236 @@
+package funcs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// The comparison functions below work the way the text/template ones do:
+// the basic kinds are compared by value (e.g. `int` and `uint8`), and the
+// interfaces by their dynamic values. The errors (e.g. incompatible types)
+// are written to the optional `ew` writer, and the result is false.
+
+var (
+	errBadComparisonType = errors.New("invalid type for comparison")
+	errBadComparison     = errors.New("incompatible types for comparison")
+	errNoComparison      = errors.New("missing argument for comparison")
+)
+
+type kind int
+
+const (
+	invalidKind kind = iota
+	boolKind
+	complexKind
+	intKind
+	floatKind
+	stringKind
+	uintKind
+)
+
+func basicKind(v reflect.Value) (kind, error) {
+	switch v.Kind() {
+	case reflect.Bool:
+		return boolKind, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return intKind, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return uintKind, nil
+	case reflect.Float32, reflect.Float64:
+		return floatKind, nil
+	case reflect.Complex64, reflect.Complex128:
+		return complexKind, nil
+	case reflect.String:
+		return stringKind, nil
+	}
+	return invalidKind, errBadComparisonType
+}
+
+// Returns the value the interface holds, or the value itself.
+func indirectInterface(v reflect.Value) reflect.Value {
+	if v.Kind() != reflect.Interface || v.IsNil() {
+		return v
+	}
+	return v.Elem()
+}
+
+func isNil(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Pointer, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
+
+// Evaluates `{{eq x y1 y2}}`: reports if `x` equals any of `ys`.
+func Eq(ew io.Writer, x any, ys ...any) bool {
+	ws := make([]reflect.Value, len(ys))
+	for i, y := range ys {
+		ws[i] = reflect.ValueOf(y)
+	}
+	truth, err := eq(reflect.ValueOf(x), ws...)
+	if err != nil {
+		report(ew, fmt.Errorf("error calling eq: %w", err))
+	}
+	return truth
+}
+
+// Evaluates `{{ne x y}}`.
+func Ne(ew io.Writer, x, y any) bool {
+	truth, err := eq(reflect.ValueOf(x), reflect.ValueOf(y))
+	if err != nil {
+		report(ew, fmt.Errorf("error calling ne: %w", err))
+		return false
+	}
+	return !truth
+}
+
+// Evaluates `{{lt x y}}`.
+func Lt(ew io.Writer, x, y any) bool {
+	truth, err := lt(reflect.ValueOf(x), reflect.ValueOf(y))
+	if err != nil {
+		report(ew, fmt.Errorf("error calling lt: %w", err))
+	}
+	return truth
+}
+
+// Evaluates `{{le x y}}`.
+func Le(ew io.Writer, x, y any) bool {
+	truth, err := le(reflect.ValueOf(x), reflect.ValueOf(y))
+	if err != nil {
+		report(ew, fmt.Errorf("error calling le: %w", err))
+	}
+	return truth
+}
+
+// Evaluates `{{gt x y}}`.
+func Gt(ew io.Writer, x, y any) bool {
+	truth, err := le(reflect.ValueOf(x), reflect.ValueOf(y))
+	if err != nil {
+		report(ew, fmt.Errorf("error calling gt: %w", err))
+		return false
+	}
+	return !truth
+}
+
+// Evaluates `{{ge x y}}`.
+func Ge(ew io.Writer, x, y any) bool {
+	truth, err := lt(reflect.ValueOf(x), reflect.ValueOf(y))
+	if err != nil {
+		report(ew, fmt.Errorf("error calling ge: %w", err))
+		return false
+	}
+	return !truth
+}
+
+func eq(v reflect.Value, ws ...reflect.Value) (bool, error) {
+	v = indirectInterface(v)
+	if len(ws) == 0 {
+		return false, errNoComparison
+	}
+	k1, _ := basicKind(v)
+	for _, w := range ws {
+		w = indirectInterface(w)
+		k2, _ := basicKind(w)
+		truth := false
+		if k1 != k2 {
+			// Integers are compared regardless of their signs
+			switch {
+			case k1 == intKind && k2 == uintKind:
+				truth = v.Int() >= 0 && uint64(v.Int()) == w.Uint()
+			case k1 == uintKind && k2 == intKind:
+				truth = w.Int() >= 0 && v.Uint() == uint64(w.Int())
+			default:
+				if v.IsValid() && w.IsValid() {
+					return false, errBadComparison
+				}
+			}
+		} else {
+			switch k1 {
+			case boolKind:
+				truth = v.Bool() == w.Bool()
+			case complexKind:
+				truth = v.Complex() == w.Complex()
+			case floatKind:
+				truth = v.Float() == w.Float()
+			case intKind:
+				truth = v.Int() == w.Int()
+			case stringKind:
+				truth = v.String() == w.String()
+			case uintKind:
+				truth = v.Uint() == w.Uint()
+			default:
+				if !canCompare(v, w) {
+					return false, fmt.Errorf("non-comparable types %s: %v, %s: %v", v, v.Type(), w.Type(), w)
+				}
+				if isNil(v) || isNil(w) {
+					truth = isNil(v) == isNil(w)
+				} else {
+					if !w.Type().Comparable() {
+						return false, fmt.Errorf("non-comparable type %s: %v", w, w.Type())
+					}
+					truth = v.Interface() == w.Interface()
+				}
+			}
+		}
+		if truth {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Nil could be compared with the values of any type, and the other ones
+// must have the same type.
+func canCompare(v, w reflect.Value) bool {
+	if !v.IsValid() || !w.IsValid() {
+		return true
+	}
+	return v.Type() == w.Type()
+}
+
+func lt(v, w reflect.Value) (bool, error) {
+	v = indirectInterface(v)
+	k1, err := basicKind(v)
+	if err != nil {
+		return false, err
+	}
+	w = indirectInterface(w)
+	k2, err := basicKind(w)
+	if err != nil {
+		return false, err
+	}
+	if k1 != k2 {
+		// Integers are compared regardless of their signs
+		switch {
+		case k1 == intKind && k2 == uintKind:
+			return v.Int() < 0 || uint64(v.Int()) < w.Uint(), nil
+		case k1 == uintKind && k2 == intKind:
+			return w.Int() >= 0 && v.Uint() < uint64(w.Int()), nil
+		}
+		return false, errBadComparison
+	}
+	switch k1 {
+	case floatKind:
+		return v.Float() < w.Float(), nil
+	case intKind:
+		return v.Int() < w.Int(), nil
+	case stringKind:
+		return v.String() < w.String(), nil
+	case uintKind:
+		return v.Uint() < w.Uint(), nil
+	}
+	return false, errBadComparisonType
+}
+
+func le(v, w reflect.Value) (bool, error) {
+	if truth, err := lt(v, w); truth || err != nil {
+		return truth, err
+	}
+	return eq(v, w)
+}
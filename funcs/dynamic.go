@@ -0,0 +1,386 @@
+package funcs
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+)
+
+// The functions below evaluate values of unknown (interface) types by
+// reflection the way text/template does. They write errors to the
+// optional `ew` writer and return zero values, so the rendering goes on.
+
+var errorType = reflect.TypeFor[error]()
+
+func report(ew io.Writer, err error) {
+	if _, ok := ew.(executeWriter); ok {
+		panic(executeError{err})
+	}
+	if ew != nil {
+		fmt.Fprintln(ew, err)
+	}
+}
+
+// Same as `template.indirect`: returns the value the pointers and
+// interfaces point to, or the nil one.
+func indirect(v reflect.Value) (rv reflect.Value, isNil bool) {
+	for ; v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface; v = v.Elem() {
+		if v.IsNil() {
+			return v, true
+		}
+	}
+	return v, false
+}
+
+func valueOf(v reflect.Value) any {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// Evaluates `{{.name}}` on `v`: returns the field, the map's value by the
+// key or the result of the method called without arguments. A missing map
+// key gives nil.
+func Field(ew io.Writer, v any, name string) any {
+	res, err := evalField(reflect.ValueOf(v), name, nil, false)
+	if err != nil {
+		report(ew, err)
+		return nil
+	}
+	return valueOf(res)
+}
+
+// Evaluates `{{.name arg1 arg2}}` on `v`: calls the method with the
+// arguments. Also calls the function the field (or the map's value) holds,
+// as the `call` builtin does.
+func Method(ew io.Writer, v any, name string, args ...any) any {
+	res, err := evalField(reflect.ValueOf(v), name, args, true)
+	if err != nil {
+		report(ew, err)
+		return nil
+	}
+	return valueOf(res)
+}
+
+func evalField(receiver reflect.Value, name string, args []any, callee bool) (reflect.Value, error) {
+	if !receiver.IsValid() {
+		return reflect.Value{}, nil
+	}
+	typ := receiver.Type()
+	receiver, isNil := indirect(receiver)
+	if receiver.Kind() == reflect.Interface && isNil {
+		return reflect.Value{}, fmt.Errorf("nil pointer evaluating %s.%s", typ, name)
+	}
+	// Unless it's an interface, need to get to a value of type *T to
+	// guarantee we see all methods of both T and *T.
+	ptr := receiver
+	if ptr.Kind() != reflect.Interface && ptr.Kind() != reflect.Pointer && ptr.CanAddr() {
+		ptr = ptr.Addr()
+	}
+	if m := ptr.MethodByName(name); m.IsValid() {
+		return call(name, m, args)
+	}
+	var field reflect.Value
+	switch receiver.Kind() {
+	case reflect.Struct:
+		if tf, ok := receiver.Type().FieldByName(name); ok {
+			if !tf.IsExported() {
+				return reflect.Value{}, fmt.Errorf("%s is an unexported field of struct type %s", name, typ)
+			}
+			f, err := receiver.FieldByIndexErr(tf.Index)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			field = f
+		}
+	case reflect.Map:
+		key := reflect.ValueOf(name)
+		if key.Type().AssignableTo(receiver.Type().Key()) {
+			field = receiver.MapIndex(key)
+			if !field.IsValid() {
+				return field, nil
+			}
+		}
+	case reflect.Pointer:
+		if isNil {
+			return reflect.Value{}, fmt.Errorf("nil pointer evaluating %s.%s", typ, name)
+		}
+	}
+	if !field.IsValid() {
+		return reflect.Value{}, fmt.Errorf("can't evaluate field %s in type %s", name, typ)
+	}
+	if callee {
+		fn, _ := indirect(field)
+		if fn.Kind() != reflect.Func {
+			return reflect.Value{}, fmt.Errorf("%s is not a method but has arguments", name)
+		}
+		return call(name, fn, args)
+	}
+	return field, nil
+}
+
+// Calls the function with the arguments. It must return a value, or a
+// value and an error.
+func call(name string, fn reflect.Value, args []any) (reflect.Value, error) {
+	if fn.IsNil() {
+		return reflect.Value{}, fmt.Errorf("call of nil function %s", name)
+	}
+	typ := fn.Type()
+	numIn := typ.NumIn()
+	if typ.IsVariadic() {
+		if len(args) < numIn-1 {
+			return reflect.Value{}, fmt.Errorf("wrong number of args for %s: want at least %d got %d", name, numIn-1, len(args))
+		}
+	} else if len(args) != numIn {
+		return reflect.Value{}, fmt.Errorf("wrong number of args for %s: want %d got %d", name, numIn, len(args))
+	}
+	switch {
+	case typ.NumOut() == 1:
+	case typ.NumOut() == 2 && typ.Out(1) == errorType:
+	default:
+		return reflect.Value{}, fmt.Errorf("can't call method/function %s with %d results", name, typ.NumOut())
+	}
+	in := make([]reflect.Value, len(args))
+	for i, a := range args {
+		var t reflect.Type
+		if typ.IsVariadic() && i >= numIn-1 {
+			t = typ.In(numIn - 1).Elem()
+		} else {
+			t = typ.In(i)
+		}
+		v, err := prepareArg(reflect.ValueOf(a), t)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("wrong type for arg %d of %s: %w", i, name, err)
+		}
+		in[i] = v
+	}
+	out := fn.Call(in)
+	if len(out) == 2 && !out[1].IsNil() {
+		return out[0], fmt.Errorf("error calling %s: %w", name, out[1].Interface().(error))
+	}
+	return out[0], nil
+}
+
+// Converts the value to the type, so it could be passed as an argument.
+// Numbers are converted between kinds, cause the template constants are
+// untyped (e.g. `1` is passed as `int`).
+func prepareArg(v reflect.Value, t reflect.Type) (reflect.Value, error) {
+	if !v.IsValid() {
+		switch t.Kind() {
+		case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Pointer, reflect.Slice:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, fmt.Errorf("value is nil; should be of type %s", t)
+	}
+	if v.Type().AssignableTo(t) {
+		return v, nil
+	}
+	if isNumber(v.Kind()) && isNumber(t.Kind()) {
+		return v.Convert(t), nil
+	}
+	return reflect.Value{}, fmt.Errorf("value has type %s; should be %s", v.Type(), t)
+}
+
+func isNumber(k reflect.Kind) bool {
+	return reflect.Int <= k && k <= reflect.Float64
+}
+
+// Evaluates `{{index v key1 key2}}`. A missing map key gives the zero
+// value.
+func Index(ew io.Writer, v any, keys ...any) any {
+	item, isNil := indirect(reflect.ValueOf(v))
+	if !item.IsValid() {
+		report(ew, errors.New("index of untyped nil"))
+		return nil
+	}
+	for _, k := range keys {
+		if item, isNil = indirect(item); isNil {
+			report(ew, errors.New("index of nil pointer"))
+			return nil
+		}
+		key, _ := indirect(reflect.ValueOf(k))
+		switch item.Kind() {
+		case reflect.Array, reflect.Slice, reflect.String:
+			if !key.IsValid() {
+				report(ew, errors.New("cannot index slice/array with nil"))
+				return nil
+			}
+			if !key.CanInt() && !key.CanUint() {
+				report(ew, fmt.Errorf("cannot index slice/array with type %s", key.Type()))
+				return nil
+			}
+			var i int
+			if key.CanInt() {
+				i = int(key.Int())
+			} else {
+				i = int(key.Uint())
+			}
+			if i < 0 || i >= item.Len() {
+				report(ew, fmt.Errorf("error calling index: index out of range: %d", i))
+				return nil
+			}
+			item = item.Index(i)
+		case reflect.Map:
+			key, err := prepareArg(key, item.Type().Key())
+			if err != nil {
+				report(ew, fmt.Errorf("error calling index: %w", err))
+				return nil
+			}
+			if x := item.MapIndex(key); x.IsValid() {
+				item = x
+			} else {
+				item = reflect.Zero(item.Type().Elem())
+			}
+		default:
+			report(ew, fmt.Errorf("can't index item of type %s", item.Type()))
+			return nil
+		}
+	}
+	return valueOf(item)
+}
+
+// Evaluates `{{len v}}`.
+func Len(ew io.Writer, v any) int {
+	item, isNil := indirect(reflect.ValueOf(v))
+	if isNil {
+		report(ew, errors.New("len of nil pointer"))
+		return 0
+	}
+	switch item.Kind() {
+	case reflect.Array, reflect.Chan, reflect.Map, reflect.Slice, reflect.String:
+		return item.Len()
+	case reflect.Invalid:
+		report(ew, errors.New("len of untyped nil"))
+		return 0
+	}
+	report(ew, fmt.Errorf("len of type %s", item.Type()))
+	return 0
+}
+
+// Evaluates `{{range v}}`: calls `fn` with each key (or index) and value
+// until it returns false. Maps are iterated in the sorted key order, and
+// iterator functions are called (see `rangeFunc`).
+// Reports if there was an iteration, so the `{{else}}` branch isn't
+// executed otherwise. `vars` is the number of the declared variables (zero
+// for dot), cause the only one gets the first value of `iter.Seq2`.
+func Range(ew io.Writer, v any, vars int, fn func(k, v any) bool) bool {
+	val, _ := indirect(reflect.ValueOf(v))
+	switch val.Kind() {
+	case reflect.Array, reflect.Slice:
+		for i := range val.Len() {
+			if !fn(i, valueOf(val.Index(i))) {
+				break
+			}
+		}
+		return val.Len() > 0
+	case reflect.Map:
+		keys := val.MapKeys()
+		slices.SortFunc(keys, compareKeys)
+		for _, k := range keys {
+			if !fn(valueOf(k), valueOf(val.MapIndex(k))) {
+				break
+			}
+		}
+		return len(keys) > 0
+	case reflect.Chan:
+		if val.IsNil() {
+			return false
+		}
+		if val.Type().ChanDir() == reflect.SendDir {
+			report(ew, fmt.Errorf("range over send-only channel %v", v))
+			return false
+		}
+		i := 0
+		for ; ; i++ {
+			elem, ok := val.Recv()
+			if !ok || !fn(i, valueOf(elem)) {
+				break
+			}
+		}
+		return i > 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := val.Convert(reflect.TypeFor[int]()).Int()
+		for i := range int(n) {
+			if !fn(i, i) {
+				break
+			}
+		}
+		return n > 0
+	case reflect.Func:
+		if yt := yieldType(val.Type()); yt != nil {
+			return rangeFunc(val, yt, vars, fn)
+		}
+	case reflect.Invalid:
+		// e.g. a nil map
+		return false
+	}
+	report(ew, fmt.Errorf("range can't iterate over %v", v))
+	return false
+}
+
+// Returns the type of `yield` if `t` is an iterator function (e.g.
+// `iter.Seq`), or nil.
+func yieldType(t reflect.Type) reflect.Type {
+	if t.NumIn() != 1 || t.NumOut() != 0 {
+		return nil
+	}
+	yt := t.In(0)
+	if yt.Kind() != reflect.Func || yt.NumOut() != 1 || yt.Out(0) != reflect.TypeFor[bool]() {
+		return nil
+	}
+	if n := yt.NumIn(); n != 1 && n != 2 {
+		return nil
+	}
+	return yt
+}
+
+// Calls the iterator function with `yield` calling `fn`: with nil and the
+// value for `iter.Seq`, and with both values for `iter.Seq2` (or nil and
+// the first one if there are less than two `vars`). The iterator is called
+// by reflection, since `reflect.Value.Seq` requires Go 1.23.
+func rangeFunc(val reflect.Value, yt reflect.Type, vars int, fn func(k, v any) bool) bool {
+	if val.IsNil() {
+		return false
+	}
+	ran, stopped := false, false
+	yield := reflect.MakeFunc(yt, func(args []reflect.Value) []reflect.Value {
+		if !stopped {
+			ran = true
+			if len(args) == 1 || vars < 2 {
+				stopped = !fn(nil, valueOf(args[0]))
+			} else {
+				stopped = !fn(valueOf(args[0]), valueOf(args[1]))
+			}
+		}
+		return []reflect.Value{reflect.ValueOf(!stopped)}
+	})
+	val.Call([]reflect.Value{yield})
+	return ran
+}
+
+// Compares map keys of the basic kinds.
+func compareKeys(a, b reflect.Value) int {
+	switch {
+	case a.CanInt():
+		return cmp.Compare(a.Int(), b.Int())
+	case a.CanUint():
+		return cmp.Compare(a.Uint(), b.Uint())
+	case a.CanFloat():
+		return cmp.Compare(a.Float(), b.Float())
+	case a.Kind() == reflect.String:
+		return cmp.Compare(a.String(), b.String())
+	case a.Kind() == reflect.Bool:
+		if a.Bool() == b.Bool() {
+			return 0
+		} else if a.Bool() {
+			return 1
+		}
+		return -1
+	}
+	return cmp.Compare(fmt.Sprint(valueOf(a)), fmt.Sprint(valueOf(b)))
+}
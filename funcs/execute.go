@@ -0,0 +1,49 @@
+package funcs
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// Stops the rendering of `Execute` with the error.
+type executeError struct {
+	err error
+}
+
+// The errors are reported to the writer by the functions above, and it stops
+// the rendering at the first one (see `report`). The other writes (e.g. by
+// the user's functions) are turned into errors.
+type executeWriter struct{}
+
+func (executeWriter) Write(p []byte) (int, error) {
+	panic(executeError{errors.New(strings.TrimSuffix(string(p), "\n"))})
+}
+
+// Executes the rendering like `Template.Execute` does: it stops at the first
+// error written to `ew` and returns it, e.g.
+//
+//	return tmtr.Execute(func(errOutput io.Writer) {
+//		tmtr.WriteString(output, data.Title, errOutput)
+//	})
+func Execute(render func(ew io.Writer)) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			e, ok := v.(executeError)
+			if !ok {
+				panic(v)
+			}
+			err = e.err
+		}
+	}()
+	render(executeWriter{})
+	return nil
+}
+
+// Reports the error of a nested `Execute`, e.g.
+// `tmtr.Check(errOutput, RenderHeader(output, data))`.
+func Check(ew io.Writer, err error) {
+	if err != nil {
+		report(ew, err)
+	}
+}
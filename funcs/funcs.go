@@ -1,28 +1,54 @@
 package funcs
 
 import (
+	"cmp"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
+	"slices"
+	"strconv"
 	"strings"
 	"unicode"
 )
 
 func Write(w io.Writer, v any, ew io.Writer) {
 	if s, ok := v.(string); ok {
-		writeString(w, s, ew)
+		WriteString(w, s, ew)
 	} else {
-		writeString(w, fmt.Sprint(v), ew)
+		WriteString(w, fmt.Sprint(v), ew)
 	}
 }
 
-func writeString(w io.Writer, s string, ew io.Writer) {
-	if _, err := io.WriteString(w, s); err != nil && ew != nil {
-		fmt.Fprintln(ew, err)
+func WriteString(w io.Writer, s string, ew io.Writer) {
+	if _, err := io.WriteString(w, s); err != nil {
+		report(ew, err)
 	}
 }
 
+func writeBytes(w io.Writer, b []byte, ew io.Writer) {
+	if _, err := w.Write(b); err != nil {
+		report(ew, err)
+	}
+}
+
+// Same as `Write(w, v, ew)`, but without boxing and `fmt`.
+func WriteInt(w io.Writer, v int64, ew io.Writer) {
+	var buf [20]byte
+	writeBytes(w, strconv.AppendInt(buf[:0], v, 10), ew)
+}
+
+// Same as `Write(w, v, ew)`, but without boxing and `fmt`.
+func WriteUint(w io.Writer, v uint64, ew io.Writer) {
+	var buf [20]byte
+	writeBytes(w, strconv.AppendUint(buf[:0], v, 10), ew)
+}
+
+// Same as `Write(w, v, ew)`, but without boxing and `fmt`.
+func WriteBool(w io.Writer, v bool, ew io.Writer) {
+	WriteString(w, strconv.FormatBool(v), ew)
+}
+
 func IsTrue(x any) bool {
 	truth, _ := template.IsTrue(x)
 	return truth
@@ -58,12 +84,42 @@ func Or(args ...any) any {
 
 func MayBe[T any](ew io.Writer, fn func() (T, error)) T {
 	v, err := fn()
-	if err != nil && ew != nil {
-		fmt.Fprintln(ew, err)
+	if err != nil {
+		report(ew, err)
 	}
 	return v
 }
 
+// Makes a function returning the results of a call, so it could be passed to
+// `MayBe`, e.g. `MayBe(ew, Result(strconv.Atoi(s)))`.
+func Result[T any](v T, err error) func() (T, error) {
+	return func() (T, error) {
+		return v, err
+	}
+}
+
+// Returns the map's keys in the sorted order, so `{{range}}` iterates the map
+// like text/template does.
+func SortedKeys[M ~map[K]V, K cmp.Ordered, V any](m M) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Like `SortedKeys`, but for the boolean keys: false goes before true.
+func SortedBoolKeys[M ~map[K]V, K ~bool, V any](m M) []K {
+	keys := make([]K, 0, len(m))
+	for _, k := range []K{false, true} {
+		if _, ok := m[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
 const filterFailsafe = "ZgotmplZ"
 
 type valueType int
@@ -109,6 +165,11 @@ func EscapeHTMLAttr(data ...any) string {
 	if t == valueTypeHTML || t == valueTypeHTMLAttr {
 		return s
 	}
+	return EscapeHTMLAttrString(s)
+}
+
+// Same as `EscapeHTMLAttr(s)`.
+func EscapeHTMLAttrString(s string) string {
 	return template.HTMLEscapeString(s)
 }
 
@@ -118,7 +179,12 @@ func EscapeUnquotedHTMLAttr(data ...any) string {
 	if t == valueTypeHTML || t == valueTypeHTMLAttr {
 		return s
 	}
-	return fmt.Sprintf("%q", EscapeHTMLAttr(s))
+	return EscapeUnquotedHTMLAttrString(s)
+}
+
+// Same as `EscapeUnquotedHTMLAttr(s)`.
+func EscapeUnquotedHTMLAttrString(s string) string {
+	return fmt.Sprintf("%q", EscapeHTMLAttrString(s))
 }
 
 func EscapeComment(...any) string {
@@ -131,6 +197,11 @@ func EscapeCSS(data ...any) string {
 	if t == valueTypeCSS {
 		return s
 	}
+	return EscapeCSSString(s)
+}
+
+// Same as `EscapeCSS(s)`.
+func EscapeCSSString(s string) string {
 	var buf strings.Builder
 	buf.Grow(len(s))
 	for i := range len(s) {
@@ -154,7 +225,15 @@ func EscapeCSS(data ...any) string {
 // Use `template.CSS` to bypass.
 func FilterCSS(data ...any) string {
 	s, t := stringify(data)
-	if t == valueTypeCSS || len(s) == 0 {
+	if t == valueTypeCSS {
+		return s
+	}
+	return FilterCSSString(s)
+}
+
+// Same as `FilterCSS(s)`.
+func FilterCSSString(s string) string {
+	if len(s) == 0 {
 		return s
 	}
 	for _, r := range s {
@@ -176,6 +255,11 @@ func FilterHTMLTagContent(data ...any) string {
 	if t == valueTypeHTML {
 		return s
 	}
+	return FilterHTMLTagContentString(s)
+}
+
+// Same as `FilterHTMLTagContent(s)`.
+func FilterHTMLTagContentString(s string) string {
 	// Passing an empty string to smth like `<input checked {{.}}=...>`
 	// leads to `<input checked =...>`, which could be harmful.
 	if len(s) == 0 {
@@ -199,6 +283,11 @@ func EscapeHTML(data ...any) string {
 	if t == valueTypeHTML {
 		return s
 	}
+	return EscapeHTMLString(s)
+}
+
+// Same as `EscapeHTML(s)`.
+func EscapeHTMLString(s string) string {
 	return template.HTMLEscapeString(s)
 }
 
@@ -239,6 +328,11 @@ var jsStrRepls = map[rune]string{
 // No bypassing.
 func EscapeJSRegexp(data ...any) string {
 	s, _ := stringify(data)
+	return EscapeJSRegexpString(s)
+}
+
+// Same as `EscapeJSRegexp(s)`.
+func EscapeJSRegexpString(s string) string {
 	// Passing an empty string to smth like `/{{.}}/`
 	// leads to `//`, which is invalid.
 	if len(s) == 0 {
@@ -275,6 +369,11 @@ func EscapeJSStr(data ...any) string {
 	if t == valueTypeJSStr {
 		return s
 	}
+	return EscapeJSStrString(s)
+}
+
+// Same as `EscapeJSStr(s)`.
+func EscapeJSStrString(s string) string {
 	var buf strings.Builder
 	buf.Grow(len(s))
 	for _, r := range s {
@@ -301,6 +400,11 @@ func EscapeJSTmplLit(data ...any) string {
 	return EscapeJSStr(data...)
 }
 
+// Same as `EscapeJSTmplLit(s)`.
+func EscapeJSTmplLitString(s string) string {
+	return EscapeJSStrString(s)
+}
+
 // Checks an input string is valid JSON by unmarshalling it. If failed, then
 // returns ";/* ERROR */null;" and writes an actual error to the optional `ew`
 // writer. Also returns `;` if an input string is empty.
@@ -310,15 +414,18 @@ func EscapeJS(ew io.Writer, data ...any) string {
 	if t == valueTypeJS {
 		return s
 	}
+	return EscapeJSString(ew, s)
+}
+
+// Same as `EscapeJS(ew, s)`.
+func EscapeJSString(ew io.Writer, s string) string {
 	// For instance `x=y/{{.}}*z` shouldn't become `x=y/*z`
 	if len(s) == 0 {
 		return `;`
 	}
 	var js any
 	if err := json.Unmarshal([]byte(s), &js); err != nil {
-		if ew != nil {
-			fmt.Fprintln(ew, err)
-		}
+		report(ew, err)
 		return ";/* ERROR */null;"
 	}
 	return s
@@ -329,6 +436,11 @@ func EscapeRCData(data ...any) string {
 	return EscapeHTML(data...)
 }
 
+// Same as `EscapeRCData(s)`.
+func EscapeRCDataString(s string) string {
+	return EscapeHTMLString(s)
+}
+
 // https://infra.spec.whatwg.org/#ascii-whitespace
 const asciiWhitespaces = " \t\n\f\r"
 
@@ -346,6 +458,11 @@ func FilterAndEscapeSrcset(ew io.Writer, data ...any) string {
 	if t == valueTypeSrcset {
 		return s
 	}
+	return FilterAndEscapeSrcsetString(ew, s)
+}
+
+// Same as `FilterAndEscapeSrcset(ew, s)`.
+func FilterAndEscapeSrcsetString(ew io.Writer, s string) string {
 	u := strings.Trim(s, asciiWhitespaces)
 	if i := strings.IndexAny(s, asciiWhitespaces); i != -1 {
 		u = s[:i]
@@ -353,9 +470,7 @@ func FilterAndEscapeSrcset(ew io.Writer, data ...any) string {
 	switch fastURLScheme(s) {
 	case "", "http", "https":
 	default:
-		if ew != nil {
-			fmt.Fprintf(ew, "url \"%s\" is not safe\n", u)
-		}
+		report(ew, fmt.Errorf("url \"%s\" is not safe", u))
 		return "#" + filterFailsafe
 	}
 	return s
@@ -367,6 +482,11 @@ func EscapeURL(data ...any) string {
 	if t == valueTypeURL {
 		return s
 	}
+	return EscapeURLString(s)
+}
+
+// Same as `EscapeURL(s)`.
+func EscapeURLString(s string) string {
 	return template.URLQueryEscaper(s)
 }
 
@@ -376,12 +496,15 @@ func FilterURL(ew io.Writer, data ...any) string {
 	if t == valueTypeURL {
 		return s
 	}
+	return FilterURLString(ew, s)
+}
+
+// Same as `FilterURL(ew, s)`.
+func FilterURLString(ew io.Writer, s string) string {
 	switch fastURLScheme(s) {
 	case "", "http", "https", "mailto":
 	default:
-		if ew != nil {
-			fmt.Fprintf(ew, "url \"%s\" is not safe\n", s)
-		}
+		report(ew, fmt.Errorf("url \"%s\" is not safe", s))
 		return "#" + filterFailsafe
 	}
 	return s
@@ -394,6 +517,11 @@ func NormalizeURL(data ...any) string {
 	if t == valueTypeURL {
 		return s
 	}
+	return NormalizeURLString(s)
+}
+
+// Same as `NormalizeURL(s)`.
+func NormalizeURLString(s string) string {
 	l := len(s)
 	var buf strings.Builder
 	buf.Grow(l)
@@ -1,8 +1,10 @@
 package funcs
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"strings"
 	"testing"
 )
@@ -35,6 +37,430 @@ func TestOr(t *testing.T) {
 	}
 }
 
+func TestResult(t *testing.T) {
+	var buf strings.Builder
+	if v := MayBe(&buf, Result(1, nil)); v != 1 || buf.Len() > 0 {
+		t.Errorf("%v, %q", v, buf.String())
+	}
+	if v := MayBe(&buf, Result(2, fmt.Errorf("fail"))); v != 2 || buf.String() != "fail\n" {
+		t.Errorf("%v, %q", v, buf.String())
+	}
+}
+
+func TestWrites(t *testing.T) {
+	var buf strings.Builder
+	WriteString(&buf, "s", nil)
+	WriteInt(&buf, -12, nil)
+	WriteUint(&buf, 34, nil)
+	WriteBool(&buf, true, nil)
+	if buf.String() != "s-1234true" {
+		t.Errorf("%q", buf.String())
+	}
+}
+
+func TestStringVariants(t *testing.T) {
+	data := []string{"", "Ab09 zZ9", `O'Reilly: <i>"you"</i>?`, "javascript:alert(1)", "a b\t/x?y=%41&z=ü"}
+	for _, s := range data {
+		pairs := [][2]string{
+			{EscapeHTMLAttr(s), EscapeHTMLAttrString(s)},
+			{EscapeUnquotedHTMLAttr(s), EscapeUnquotedHTMLAttrString(s)},
+			{EscapeCSS(s), EscapeCSSString(s)},
+			{FilterCSS(s), FilterCSSString(s)},
+			{FilterHTMLTagContent(s), FilterHTMLTagContentString(s)},
+			{EscapeHTML(s), EscapeHTMLString(s)},
+			{EscapeJSRegexp(s), EscapeJSRegexpString(s)},
+			{EscapeJSStr(s), EscapeJSStrString(s)},
+			{EscapeJSTmplLit(s), EscapeJSTmplLitString(s)},
+			{EscapeJS(nil, s), EscapeJSString(nil, s)},
+			{EscapeRCData(s), EscapeRCDataString(s)},
+			{FilterAndEscapeSrcset(nil, s), FilterAndEscapeSrcsetString(nil, s)},
+			{EscapeURL(s), EscapeURLString(s)},
+			{FilterURL(nil, s), FilterURLString(nil, s)},
+			{NormalizeURL(s), NormalizeURLString(s)},
+		}
+		for i, p := range pairs {
+			if p[0] != p[1] {
+				t.Errorf("#%d: %q != %q", i, p[0], p[1])
+			}
+		}
+	}
+}
+
+// The generator skips these escapers for integers and booleans.
+func TestScalarsPassThrough(t *testing.T) {
+	for _, v := range []any{-12, uint(34), true, false} {
+		s := fmt.Sprint(v)
+		data := []string{
+			EscapeHTMLAttr(v),
+			EscapeHTML(v),
+			EscapeJS(nil, v),
+			EscapeRCData(v),
+			FilterAndEscapeSrcset(nil, v),
+			EscapeURL(v),
+			FilterURL(nil, v),
+			NormalizeURL(v),
+		}
+		for i, a := range data {
+			if a != s {
+				t.Errorf("#%d: %q != %q", i, a, s)
+			}
+		}
+	}
+}
+
+type testDynamic struct {
+	Title string
+	Ptr   *testDynamic
+	Fn    func(int) int
+	title string
+}
+
+func (d testDynamic) Upper() string { return strings.ToUpper(d.Title) }
+
+func (d *testDynamic) Prefixed(s string, n int64) string { return fmt.Sprint(s, n, d.Title) }
+
+func (d testDynamic) Fail() (string, error) { return "", fmt.Errorf("fail") }
+
+func TestField(t *testing.T) {
+	var buf strings.Builder
+	d := &testDynamic{Title: "t", Fn: func(n int) int { return n + 1 }}
+	data := []struct{ x, a any }{
+		{"t", Field(&buf, d, "Title")},
+		{"T", Field(&buf, d, "Upper")},
+		{"T", Field(&buf, *d, "Upper")},
+		{"x1t", Method(&buf, d, "Prefixed", "x", 1)},
+		{2, Method(&buf, d, "Fn", 1)},
+		{"v", Field(&buf, map[string]any{"k": "v"}, "k")},
+		{nil, Field(&buf, map[string]any{}, "k")},
+		{nil, Field(&buf, nil, "k")},
+	}
+	for _, cs := range data {
+		if cs.x != cs.a {
+			t.Errorf("%v != %v", cs.x, cs.a)
+		}
+	}
+	if buf.Len() > 0 {
+		t.Errorf("unexpected errors: %q", buf.String())
+	}
+	Field(&buf, d, "Nope")
+	Field(&buf, d, "title")
+	Field(&buf, d.Ptr, "Title")
+	Field(&buf, d, "Fail")
+	Method(&buf, *d, "Prefixed", "x", 1)
+	Method(&buf, d, "Prefixed", "x")
+	Method(&buf, d, "Title", 1)
+	expected := strings.Join([]string{
+		"can't evaluate field Nope in type *funcs.testDynamic",
+		"title is an unexported field of struct type *funcs.testDynamic",
+		"nil pointer evaluating *funcs.testDynamic.Title",
+		"error calling Fail: fail",
+		"can't evaluate field Prefixed in type funcs.testDynamic",
+		"wrong number of args for Prefixed: want 2 got 1",
+		"Title is not a method but has arguments",
+		"",
+	}, "\n")
+	if buf.String() != expected {
+		t.Errorf("%q != %q", buf.String(), expected)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	var buf strings.Builder
+	data := []struct{ x, a any }{
+		{2, Index(&buf, []int{1, 2}, 1)},
+		{3, Index(&buf, [][]int{{1}, {2, 3}}, 1, uint8(1))},
+		{byte('b'), Index(&buf, "ab", 1)},
+		{"v", Index(&buf, map[string]string{"k": "v"}, "k")},
+		{"", Index(&buf, map[string]string{}, "k")},
+		{1, Index(&buf, map[int64]int{2: 1}, 2)},
+	}
+	for _, cs := range data {
+		if cs.x != cs.a {
+			t.Errorf("%v != %v", cs.x, cs.a)
+		}
+	}
+	if buf.Len() > 0 {
+		t.Errorf("unexpected errors: %q", buf.String())
+	}
+	Index(&buf, []int{1}, 1)
+	Index(&buf, 1, 1)
+	Index(&buf, nil, 1)
+	expected := "error calling index: index out of range: 1\ncan't index item of type int\nindex of untyped nil\n"
+	if buf.String() != expected {
+		t.Errorf("%q != %q", buf.String(), expected)
+	}
+}
+
+func TestLen(t *testing.T) {
+	var buf strings.Builder
+	data := []struct{ x, a int }{
+		{2, Len(&buf, []int{1, 2})},
+		{1, Len(&buf, map[int]int{1: 1})},
+		{3, Len(&buf, "abc")},
+		{1, Len(&buf, &[1]int{})},
+		{0, Len(&buf, 1)},
+	}
+	for _, cs := range data {
+		if cs.x != cs.a {
+			t.Errorf("%v != %v", cs.x, cs.a)
+		}
+	}
+	if buf.String() != "len of type int\n" {
+		t.Errorf("%q", buf.String())
+	}
+}
+
+func TestSortedKeys(t *testing.T) {
+	if s := fmt.Sprint(SortedKeys(map[string]int{"b": 2, "a": 1, "c": 3})); s != "[a b c]" {
+		t.Error(s)
+	}
+	type id int
+	if s := fmt.Sprint(SortedKeys(map[id]bool{10: true, -1: false, 2: true})); s != "[-1 2 10]" {
+		t.Error(s)
+	}
+	if s := fmt.Sprint(SortedKeys(map[float64]int(nil))); s != "[]" {
+		t.Error(s)
+	}
+	type flag bool
+	if s := fmt.Sprint(SortedBoolKeys(map[flag]int{true: 1, false: 0})); s != "[false true]" {
+		t.Error(s)
+	}
+	if s := fmt.Sprint(SortedBoolKeys(map[bool]int{true: 1})); s != "[true]" {
+		t.Error(s)
+	}
+}
+
+func TestRange(t *testing.T) {
+	collect := func(v any, limit int) (string, bool) {
+		var b strings.Builder
+		ok := Range(nil, v, 2, func(k, v any) bool {
+			fmt.Fprintf(&b, "%v:%v ", k, v)
+			limit--
+			return limit > 0
+		})
+		return b.String(), ok
+	}
+	ch := make(chan string, 2)
+	ch <- "a"
+	ch <- "b"
+	close(ch)
+	empty := make(chan string)
+	close(empty)
+	seq := func(yield func(string) bool) {
+		_ = yield("a") && yield("b")
+	}
+	seq2 := func(yield func(int, string) bool) {
+		_ = yield(1, "a") && yield(2, "b")
+	}
+	data := []struct {
+		v     any
+		limit int
+		s     string
+		ok    bool
+	}{
+		{[]string{"a", "b"}, 10, "0:a 1:b ", true},
+		{[]string{"a", "b"}, 1, "0:a ", true},
+		{map[string]int{"b": 2, "a": 1, "c": 3}, 10, "a:1 b:2 c:3 ", true},
+		{map[int]bool{10: true, 2: false}, 10, "2:false 10:true ", true},
+		{ch, 10, "0:a 1:b ", true},
+		{3, 10, "0:0 1:1 2:2 ", true},
+		{seq, 10, "<nil>:a <nil>:b ", true},
+		{seq2, 10, "1:a 2:b ", true},
+		{seq2, 1, "1:a ", true},
+		{func(yield func(string) bool) {}, 10, "", false},
+		{empty, 10, "", false},
+		{[]int{}, 10, "", false},
+		{nil, 10, "", false},
+	}
+	for _, cs := range data {
+		if s, ok := collect(cs.v, cs.limit); s != cs.s || ok != cs.ok {
+			t.Errorf("%q, %v != %q, %v", s, ok, cs.s, cs.ok)
+		}
+	}
+	// The only variable of `iter.Seq2` is the first value
+	var keys []any
+	Range(nil, seq2, 1, func(k, v any) bool {
+		keys = append(keys, k, v)
+		return true
+	})
+	if fmt.Sprint(keys) != "[<nil> 1 <nil> 2]" {
+		t.Error(keys)
+	}
+}
+
+func TestComparison(t *testing.T) {
+	type myInt int
+	var nilPtr *int
+	one := 1
+	eq := func(ew io.Writer, x, y any) bool {
+		return Eq(ew, x, y)
+	}
+	data := []struct {
+		name string
+		fn   func(ew io.Writer, x, y any) bool
+		x, y any
+		res  bool
+		err  string
+	}{
+		{"eq", eq, 1, uint8(1), true, ""},
+		{"eq", eq, int64(-1), uint(1), false, ""},
+		{"eq", eq, myInt(2), 2, true, ""},
+		{"eq", eq, "a", any("a"), true, ""},
+		{"eq", eq, nilPtr, nil, true, ""},
+		{"eq", eq, &one, &one, true, ""},
+		{"eq", eq, 1, "1", false, "error calling eq: incompatible types for comparison\n"},
+		{"eq", eq, []int{}, []int{}, false, "error calling eq: non-comparable type []: []int\n"},
+		{"ne", Ne, 1.5, 1.5, false, ""},
+		{"ne", Ne, true, false, true, ""},
+		{"ne", Ne, 1, "1", false, "error calling ne: incompatible types for comparison\n"},
+		{"lt", Lt, -1, uint(0), true, ""},
+		{"lt", Lt, uint(0), -1, false, ""},
+		{"lt", Lt, "a", "b", true, ""},
+		{"lt", Lt, true, false, false, "error calling lt: invalid type for comparison\n"},
+		{"le", Le, 2, int8(2), true, ""},
+		{"le", Le, 2.5, 2.0, false, ""},
+		{"gt", Gt, uint16(3), 2, true, ""},
+		{"gt", Gt, 1, 1.0, false, "error calling gt: incompatible types for comparison\n"},
+		{"ge", Ge, "b", "b", true, ""},
+		{"ge", Ge, 1, 2, false, ""},
+	}
+	for _, cs := range data {
+		var ew strings.Builder
+		if res := cs.fn(&ew, cs.x, cs.y); res != cs.res || ew.String() != cs.err {
+			t.Errorf("%s %#v %#v: %v, %q != %v, %q", cs.name, cs.x, cs.y, res, ew.String(), cs.res, cs.err)
+		}
+	}
+	if !Eq(nil, 3, 1, 2, 3) || Eq(nil, 4, 1, 2, 3) {
+		t.Error("eq with several args")
+	}
+	var ew strings.Builder
+	if Eq(&ew, 1) || ew.String() != "error calling eq: missing argument for comparison\n" {
+		t.Error(ew.String())
+	}
+}
+
+func TestGuards(t *testing.T) {
+	var ew strings.Builder
+	ok := NotNil(&ew, "a:1:2", false, "*T.X") && InRange(&ew, "a:1:2", 0, 1) && SliceInRange(&ew, "a:1:2", 3, 0, 3, 3)
+	if !ok || ew.Len() > 0 {
+		t.Error(ew.String())
+	}
+	data := []struct {
+		ok  bool
+		err string
+	}{
+		{NotNil(&ew, "a:1:2", true, "*T.X"), "a:1:2: nil pointer evaluating *T.X\n"},
+		{InRange(&ew, "a:1:2", 1, 1), "a:1:2: error calling index: index out of range: 1\n"},
+		{InRange(&ew, "a:1:2", -1, 1), "a:1:2: error calling index: index out of range: -1\n"},
+		{SliceInRange(&ew, "a:1:2", 3, 4), "a:1:2: error calling slice: index out of range: 4\n"},
+		{SliceInRange(&ew, "a:1:2", 3, 2, 1), "a:1:2: error calling slice: invalid slice index: 2 > 1\n"},
+	}
+	for _, cs := range data {
+		if cs.ok {
+			t.Error(cs.err)
+		}
+	}
+	var exp strings.Builder
+	for _, cs := range data {
+		exp.WriteString(cs.err)
+	}
+	if ew.String() != exp.String() {
+		t.Errorf("%q != %q", ew.String(), exp.String())
+	}
+}
+
+func TestRecover(t *testing.T) {
+	var ew strings.Builder
+	pos := "a:1:2"
+	func() {
+		defer Recover(&ew, &pos)
+		pos = "a:3:4"
+		panic(io.EOF)
+	}()
+	if s := ew.String(); s != "a:3:4: panic: EOF\n" {
+		t.Error(s)
+	}
+	err := &PanicError{At: pos, Value: io.EOF}
+	if !errors.Is(err, io.EOF) {
+		t.Error(err)
+	}
+	ew.Reset()
+	func() {
+		defer Recover(&ew, &pos)
+	}()
+	if ew.Len() > 0 {
+		t.Error(ew.String())
+	}
+}
+
+func TestExecute(t *testing.T) {
+	var w strings.Builder
+	err := Execute(func(ew io.Writer) {
+		WriteString(&w, "a", ew)
+		MayBe(ew, func() (int, error) { return 0, io.EOF })
+		WriteString(&w, "b", ew)
+	})
+	if err != io.EOF || w.String() != "a" {
+		t.Error(err, w.String())
+	}
+	err = Execute(func(ew io.Writer) {
+		pos := "a:1:2"
+		defer Recover(ew, &pos)
+		Check(ew, Execute(func(ew io.Writer) {
+			Check(ew, nil)
+			Eq(ew, 1, "1")
+		}))
+		t.Error("not stopped")
+	})
+	if err == nil || err.Error() != "error calling eq: incompatible types for comparison" {
+		t.Error(err)
+	}
+	err = Execute(func(ew io.Writer) {
+		pos := "a:1:2"
+		defer Recover(ew, &pos)
+		panic("oops")
+	})
+	if err == nil || err.Error() != "a:1:2: panic: oops" {
+		t.Error(err)
+	}
+	if err := Execute(func(ew io.Writer) {}); err != nil {
+		t.Error(err)
+	}
+}
+
+type failingWriter struct {
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.n++
+	return 0, io.ErrClosedPipe
+}
+
+func TestOutput(t *testing.T) {
+	fw := &failingWriter{}
+	out := NewOutput(fw)
+	if NewOutput(out) != out || out.Failed() {
+		t.Fail()
+	}
+	var ew strings.Builder
+	WriteString(out, "a", &ew)
+	Write(out, 1, &ew)
+	WriteInt(out, 2, &ew)
+	if fw.n != 1 || !out.Failed() || out.Err() != io.ErrClosedPipe {
+		t.Error(fw.n, out.Err())
+	}
+	if s := ew.String(); s != "io: read/write on closed pipe\n" {
+		t.Error(s)
+	}
+	var w strings.Builder
+	out = NewOutput(&w)
+	WriteString(out, "a", &ew)
+	if w.String() != "a" || out.Failed() {
+		t.Error(w.String())
+	}
+}
+
 func TestEscapeHTMLAttr(t *testing.T) {
 	data := []struct {
 		x    any
@@ -0,0 +1,48 @@
+package funcs
+
+import "io"
+
+// The output stopping at the first failed write (e.g. a disconnected
+// client): the write returns the error, so it's reported once, and the
+// following writes are skipped and succeed.
+type Output struct {
+	w   io.Writer
+	err error
+}
+
+// Wraps the writer unless it's wrapped already, so the nested templates
+// share the state.
+func NewOutput(w io.Writer) *Output {
+	if o, ok := w.(*Output); ok {
+		return o
+	}
+	return &Output{w: w}
+}
+
+func (o *Output) Write(p []byte) (int, error) {
+	if o.err != nil {
+		return len(p), nil
+	}
+	n, err := o.w.Write(p)
+	o.err = err
+	return n, err
+}
+
+func (o *Output) WriteString(s string) (int, error) {
+	if o.err != nil {
+		return len(s), nil
+	}
+	n, err := io.WriteString(o.w, s)
+	o.err = err
+	return n, err
+}
+
+// Reports if a write has failed, so the rendering could stop.
+func (o *Output) Failed() bool {
+	return o.err != nil
+}
+
+// Returns the error of the failed write if any.
+func (o *Output) Err() error {
+	return o.err
+}
@@ -0,0 +1,74 @@
+package funcs
+
+import (
+	"fmt"
+	"io"
+)
+
+// The functions below guard the actions in the safe mode: they report if
+// the action could be executed, and write the error prefixed by the
+// template position `at` otherwise, so the action is skipped instead of
+// panicking.
+
+// Guards `x.name` (`name` is the qualified one, e.g. "*main.User.Name").
+func NotNil(ew io.Writer, at string, isNil bool, name string) bool {
+	if isNil {
+		report(ew, fmt.Errorf("%s: nil pointer evaluating %s", at, name))
+	}
+	return !isNil
+}
+
+// Guards `{{index x i}}`, where `n` is the length of `x`.
+func InRange(ew io.Writer, at string, i, n int) bool {
+	if i < 0 || i >= n {
+		report(ew, fmt.Errorf("%s: error calling index: index out of range: %d", at, i))
+		return false
+	}
+	return true
+}
+
+// Guards `{{slice x i j k}}`, where `n` is the capacity of `x` (or its
+// length if it's a string, or there's only `i`).
+func SliceInRange(ew io.Writer, at string, n int, idx ...int) bool {
+	for i, x := range idx {
+		if x < 0 || x > n {
+			report(ew, fmt.Errorf("%s: error calling slice: index out of range: %d", at, x))
+			return false
+		}
+		if i > 0 && x < idx[i-1] {
+			report(ew, fmt.Errorf("%s: error calling slice: invalid slice index: %d > %d", at, idx[i-1], x))
+			return false
+		}
+	}
+	return true
+}
+
+// A panic recovered while rendering (see `Recover`).
+type PanicError struct {
+	// The template position of the action, e.g. "index.html:3:5"
+	At    string
+	Value any
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s: panic: %v", e.At, e.Value)
+}
+
+// Returns the recovered error if any.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Recovers a panic and writes it to `ew` as `*PanicError`, where `at` points
+// to the position of the action being executed. It must be deferred
+// directly, e.g. `defer tmtr.Recover(errOutput, &pos)`.
+func Recover(ew io.Writer, at *string) {
+	if v := recover(); v != nil {
+		if _, ok := v.(executeError); ok {
+			// `Execute` is stopping the rendering
+			panic(v)
+		}
+		report(ew, &PanicError{At: *at, Value: v})
+	}
+}
@@ -0,0 +1,132 @@
+package gen
+
+import (
+	"slices"
+	"strings"
+	"text/template/parse"
+	"unicode"
+)
+
+const derivedTmplMark = "$htmltemplate_"
+
+// Strips the context suffix html/template adds to the names of the
+// templates it derives (e.g. "foo$htmltemplate_stateRCDATA").
+func unmangleTmplName(name string) string {
+	if s, _, ok := strings.Cut(name, derivedTmplMark); ok {
+		return s
+	}
+	return name
+}
+
+func isDerivedTmplName(name string) bool {
+	return strings.Contains(name, derivedTmplMark)
+}
+
+// Returns the context of a derived template (e.g. "stateAttr_delimDoubleQuote"
+// for "foo$htmltemplate_stateAttr_delimDoubleQuote").
+func tmplContext(name string) string {
+	_, ctx, _ := strings.Cut(name, derivedTmplMark)
+	return ctx
+}
+
+var contextPrefixes = []string{"state", "delim", "urlPart", "jsCtx", "attr", "element"}
+
+// Returns the function name suffix for the context of a derived template,
+// e.g. "InAttrDoubleQuote" for "foo$htmltemplate_stateAttr_delimDoubleQuote".
+func contextSuffix(name string) string {
+	var b strings.Builder
+	b.WriteString("In")
+	for _, part := range strings.Split(tmplContext(name), "_") {
+		for _, p := range contextPrefixes {
+			if s, ok := strings.CutPrefix(part, p); ok && len(s) > 0 {
+				part = s
+				break
+			}
+		}
+		part = upperFirstLetter(part)
+		for _, r := range part {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
+
+// Reports if the node is `{{block "name" ...}}`. The parser turns blocks into
+// the `{{define}}` & `{{template}}` pair, so the source is checked: the node's
+// position is the one of the name.
+func isBlockNode(src string, n *parse.TemplateNode) bool {
+	pos := int(n.Position())
+	if pos > len(src) {
+		return false
+	}
+	i := strings.LastIndex(src[:pos], "{{")
+	if i == -1 {
+		return false
+	}
+	s := strings.TrimPrefix(src[i+2:pos], "-")
+	return strings.TrimSpace(s) == "block"
+}
+
+// Returns the names of the templates defined by `{{block}}`.
+func blockNames(wrappers []*tmplWrapper) map[string]bool {
+	names := make(map[string]bool)
+	for _, w := range wrappers {
+		walkNodes(w.root, func(n parse.Node) {
+			if n, ok := n.(*parse.TemplateNode); ok && isBlockNode(w.src, n) {
+				names[unmangleTmplName(n.Name)] = true
+			}
+		})
+	}
+	return names
+}
+
+// Returns the sorted names of the blocks each template's function calls
+// directly or through the other defined templates, so these blocks could be
+// overridden by the function arguments. The map's keys are the function
+// names.
+func blockOverrides(wrappers []*tmplWrapper, blocks map[string]bool) map[string][]string {
+	calls := make(map[string][]string)
+	sets := make(map[string]map[string]bool)
+	for _, w := range wrappers {
+		set := make(map[string]bool)
+		walkNodes(w.root, func(n parse.Node) {
+			if n, ok := n.(*parse.TemplateNode); ok {
+				name := unmangleTmplName(n.Name)
+				if blocks[name] {
+					set[name] = true
+				}
+				if fn, ok := w.fnNames[n.Name]; ok {
+					calls[w.fnName] = append(calls[w.fnName], fn)
+				}
+			}
+		})
+		sets[w.fnName] = set
+	}
+	// Propagate the callees' blocks until nothing changes (templates may be
+	// recursive).
+	for changed := true; changed; {
+		changed = false
+		for caller, callees := range calls {
+			for _, callee := range callees {
+				for b := range sets[callee] {
+					if !sets[caller][b] {
+						sets[caller][b] = true
+						changed = true
+					}
+				}
+			}
+		}
+	}
+	overrides := make(map[string][]string, len(sets))
+	for name, set := range sets {
+		list := make([]string, 0, len(set))
+		for b := range set {
+			list = append(list, b)
+		}
+		slices.Sort(list)
+		overrides[name] = list
+	}
+	return overrides
+}
@@ -0,0 +1,208 @@
+package gen
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	ht "html/template"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Describes a problem found in a template. `Line` and `Col` are 1-based,
+// and zero if unknown.
+type Diagnostic struct {
+	File     string   `json:"file"`
+	Line     int      `json:"line,omitempty"`
+	Col      int      `json:"col,omitempty"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	// The original error if any (e.g. `*template.Error`)
+	Err error `json:"-"`
+}
+
+// Formats a diagnostic like a compiler does, e.g.
+// "index.html:3:5: error: unknown node".
+func (d Diagnostic) String() string {
+	var b strings.Builder
+	if len(d.File) > 0 {
+		b.WriteString(d.File)
+		if d.Line > 0 {
+			fmt.Fprintf(&b, ":%d", d.Line)
+			if d.Col > 0 {
+				fmt.Fprintf(&b, ":%d", d.Col)
+			}
+		}
+		b.WriteString(": ")
+	}
+	fmt.Fprintf(&b, "%s: %s", d.Severity, d.Message)
+	return b.String()
+}
+
+type Diagnostics []Diagnostic
+
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (ds Diagnostics) Error() string {
+	lines := make([]string, len(ds))
+	for i, d := range ds {
+		lines[i] = d.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (ds Diagnostics) Unwrap() []error {
+	errs := make([]error, 0)
+	for _, d := range ds {
+		if d.Err != nil {
+			errs = append(errs, d.Err)
+		}
+	}
+	return errs
+}
+
+type DiagFormat int
+
+const (
+	DiagText DiagFormat = iota // compiler-style
+	DiagJSON                   // JSON lines
+)
+
+// Prints one diagnostic per line.
+func (ds Diagnostics) Fprint(w io.Writer, format DiagFormat) error {
+	for _, d := range ds {
+		switch format {
+		case DiagJSON:
+			b, err := json.Marshal(d)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "%s\n", b); err != nil {
+				return err
+			}
+		default:
+			if _, err := fmt.Fprintln(w, d.String()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Collects diagnostics during generation.
+type diagnostics struct {
+	list Diagnostics
+}
+
+func (c *diagnostics) add(d Diagnostic) {
+	c.list = append(c.list, d)
+}
+
+func (c *diagnostics) at(tree *parse.Tree, n parse.Node, sev Severity, format string, args ...any) {
+	d := nodeDiagnostic(tree, n)
+	d.Severity = sev
+	d.Message = fmt.Sprintf(format, args...)
+	c.add(d)
+}
+
+func (c *diagnostics) errorf(tree *parse.Tree, n parse.Node, format string, args ...any) {
+	c.at(tree, n, SeverityError, format, args...)
+}
+
+func (c *diagnostics) warnf(tree *parse.Tree, n parse.Node, format string, args ...any) {
+	c.at(tree, n, SeverityWarning, format, args...)
+}
+
+func (g *Generator) errorf(n parse.Node, format string, args ...any) {
+	g.diags.errorf(g.tree, n, format, args...)
+}
+
+func (g *Generator) warnf(n parse.Node, format string, args ...any) {
+	g.diags.warnf(g.tree, n, format, args...)
+}
+
+// Returns a diagnostic positioned at the node (its position is taken from
+// `Tree.ErrorContext`).
+func nodeDiagnostic(tree *parse.Tree, n parse.Node) Diagnostic {
+	if n == nil {
+		return Diagnostic{}
+	}
+	loc, _ := tree.ErrorContext(n)
+	d := Diagnostic{File: loc}
+	// "name:line:col", but a name could contain colons
+	if i := strings.LastIndexByte(loc, ':'); i != -1 {
+		if j := strings.LastIndexByte(loc[:i], ':'); j != -1 {
+			line, err1 := strconv.Atoi(loc[(j + 1):i])
+			col, err2 := strconv.Atoi(loc[(i + 1):])
+			if err1 == nil && err2 == nil {
+				d.File = loc[:j]
+				d.Line = line
+				d.Col = col + 1 // the context's column is 0-based
+			}
+		}
+	}
+	return d
+}
+
+var parseErrRe = regexp.MustCompile(`(?s)^template: (.+?):(\d+): (.*)$`)
+
+// Converts the parsing and escaping errors to diagnostics. Other errors
+// are returned as is.
+func errorDiagnostics(err error) error {
+	var he *ht.Error
+	if errors.As(err, &he) {
+		var d Diagnostic
+		if he.Node != nil {
+			d = nodeDiagnostic(nil, he.Node)
+		} else {
+			d.File = he.Name
+			d.Line = he.Line
+		}
+		d.Severity = SeverityError
+		d.Message = he.Description
+		d.Err = err
+		return Diagnostics{d}
+	}
+	if m := parseErrRe.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[2])
+		return Diagnostics{{
+			File:     m[1],
+			Line:     line,
+			Severity: SeverityError,
+			Message:  m[3],
+			Err:      err,
+		}}
+	}
+	return err
+}
@@ -0,0 +1,198 @@
+package gen
+
+import (
+	"go/ast"
+	"go/types"
+	"runtime"
+	"slices"
+	"strings"
+	"text/template/parse"
+
+	"github.com/apleshkov/tmtr/scopes"
+)
+
+// The prefix of the escapers' names the html/template package inserts.
+const escaperPrefix = "_html_template_"
+
+type escaper struct {
+	ident *ast.Ident
+	// Pass `errOutput` as the first argument
+	withErrOutput bool
+	// The variant taking a string (e.g. `EscapeHTMLString`), if any
+	stringIdent *ast.Ident
+	// The html/template types (e.g. "HTML") passed through as is
+	bypass []string
+	// Integers and booleans are passed through as is, cause they have no
+	// special characters for the escaper
+	scalars bool
+}
+
+// Maps the html/template escapers to their runtime equivalents.
+var escapers = map[string]escaper{
+	"_html_template_attrescaper": {
+		ident:       escapeHTMLAttrIdent,
+		stringIdent: escapeHTMLAttrStringIdent,
+		bypass:      []string{"HTML", "HTMLAttr"},
+		scalars:     true,
+	},
+	"_html_template_commentescaper": {ident: escapeCommentIdent},
+	"_html_template_cssescaper": {
+		ident:       escapeCSSIdent,
+		stringIdent: escapeCSSStringIdent,
+		bypass:      []string{"CSS"},
+	},
+	"_html_template_cssvaluefilter": {
+		ident:       filterCSSIdent,
+		stringIdent: filterCSSStringIdent,
+		bypass:      []string{"CSS"},
+	},
+	"_html_template_htmlnamefilter": {
+		ident:       filterHTMLTagContentIdent,
+		stringIdent: filterHTMLTagContentStringIdent,
+		bypass:      []string{"HTML"},
+	},
+	"_html_template_htmlescaper": {
+		ident:       escapeHTMLIdent,
+		stringIdent: escapeHTMLStringIdent,
+		bypass:      []string{"HTML"},
+		scalars:     true,
+	},
+	"_html_template_jsregexpescaper": {
+		ident:       escapeJSRegexpIdent,
+		stringIdent: escapeJSRegexpStringIdent,
+	},
+	"_html_template_jsstrescaper": {
+		ident:       escapeJSStrIdent,
+		stringIdent: escapeJSStrStringIdent,
+		bypass:      []string{"JSStr"},
+	},
+	"_html_template_jstmpllitescaper": {
+		ident:       escapeJSTmplLitIdent,
+		stringIdent: escapeJSTmplLitStringIdent,
+		bypass:      []string{"JSStr"},
+	},
+	"_html_template_jsvalescaper": {
+		ident:         escapeJSIdent,
+		withErrOutput: true,
+		stringIdent:   escapeJSStringIdent,
+		bypass:        []string{"JS"},
+		scalars:       true,
+	},
+	"_html_template_nospaceescaper": {
+		ident:       escapeUnquotedHTMLAttrIdent,
+		stringIdent: escapeUnquotedHTMLAttrStringIdent,
+		bypass:      []string{"HTML", "HTMLAttr"},
+	},
+	"_html_template_rcdataescaper": {
+		ident:       escapeRCDataIdent,
+		stringIdent: escapeRCDataStringIdent,
+		bypass:      []string{"HTML"},
+		scalars:     true,
+	},
+	"_html_template_srcsetescaper": {
+		ident:         filterAndEscapeSrcsetIdent,
+		withErrOutput: true,
+		stringIdent:   filterAndEscapeSrcsetStringIdent,
+		bypass:        []string{"Srcset"},
+		scalars:       true,
+	},
+	"_html_template_urlescaper": {
+		ident:       escapeURLIdent,
+		stringIdent: escapeURLStringIdent,
+		bypass:      []string{"URL"},
+		scalars:     true,
+	},
+	"_html_template_urlfilter": {
+		ident:         filterURLIdent,
+		withErrOutput: true,
+		stringIdent:   filterURLStringIdent,
+		bypass:        []string{"URL"},
+		scalars:       true,
+	},
+	"_html_template_urlnormalizer": {
+		ident:       normalizeURLIdent,
+		stringIdent: normalizeURLStringIdent,
+		bypass:      []string{"URL"},
+		scalars:     true,
+	},
+}
+
+func (g *Generator) escaperExpr(esc escaper, scope scopes.Scope) ast.Expr {
+	fn := &ast.SelectorExpr{
+		X:   g.useFuncs(),
+		Sel: esc.ident,
+	}
+	if esc.withErrOutput {
+		return &ast.CallExpr{
+			Fun:  fn,
+			Args: []ast.Expr{g.eoutIdent},
+		}
+	}
+	return fn
+}
+
+// Returns the escaper if the command is a sole one (i.e. a piped escaper).
+func pipedEscaper(cmd *parse.CommandNode) (escaper, bool) {
+	if len(cmd.Args) == 1 {
+		if id, ok := cmd.Args[0].(*parse.IdentifierNode); ok {
+			esc, ok := escapers[id.Ident]
+			return esc, ok
+		}
+	}
+	return escaper{}, false
+}
+
+// Escapes the piped value of a known type: the value is passed through as
+// is if the escaper bypasses it, or to the escaper's string variant (e.g.
+// `tmtr.EscapeHTMLString(x)`) otherwise. Returns nil if the type is unknown
+// or unsupported, so the generic escaper is used.
+func (g *Generator) typedEscapeExpr(esc escaper, x ast.Expr, scope scopes.Scope) ast.Expr {
+	t := g.typeOf(x)
+	switch plainKind(t) {
+	case plainInt, plainUint, plainBool:
+		if esc.scalars {
+			return x
+		}
+		return nil
+	case plainString:
+		if name := safeTypeName(t); len(name) > 0 && slices.Contains(esc.bypass, name) {
+			return x
+		}
+	default:
+		return nil
+	}
+	if esc.stringIdent == nil {
+		return nil
+	}
+	args := []ast.Expr{g.stringExpr(x)}
+	if esc.withErrOutput {
+		args = append([]ast.Expr{g.eoutIdent}, args...)
+	}
+	return g.typed(&ast.CallExpr{
+		Fun: &ast.SelectorExpr{
+			X:   g.useFuncs(),
+			Sel: esc.stringIdent,
+		},
+		Args: args,
+	}, types.Typ[types.String])
+}
+
+// Reports the escapers inserted by html/template, which have no runtime
+// equivalents (e.g. added or renamed by a newer Go release).
+func checkEscapers(trees []*parse.Tree, diags *diagnostics) {
+	for _, tree := range trees {
+		walkNodes(tree.Root, func(n parse.Node) {
+			id, ok := n.(*parse.IdentifierNode)
+			if !ok || !strings.HasPrefix(id.Ident, escaperPrefix) {
+				return
+			}
+			if _, ok := escapers[id.Ident]; !ok {
+				diags.errorf(
+					tree, id,
+					"unsupported html/template escaper %q (tmtr %s, Go %s)",
+					id.Ident, Version, runtime.Version(),
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package gen
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	tt "text/template"
+	"text/template/parse"
+
+	"github.com/apleshkov/tmtr/util"
+)
+
+// Walks the `funcMap` of the current toolchain's html/template sources, so
+// a new or renamed escaper fails the test.
+func TestAllEscapersMapped(t *testing.T) {
+	file := filepath.Join(build.Default.GOROOT, "src", "html", "template", "escape.go")
+	if _, err := os.Stat(file); err != nil {
+		t.Skipf("no html/template sources: %v", err)
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	ast.Inspect(f, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok || len(spec.Names) != 1 || spec.Names[0].Name != "funcMap" {
+			return true
+		}
+		for _, v := range spec.Values {
+			if lit, ok := v.(*ast.CompositeLit); ok {
+				for _, e := range lit.Elts {
+					if kv, ok := e.(*ast.KeyValueExpr); ok {
+						if k, ok := kv.Key.(*ast.BasicLit); ok {
+							name, _ := strconv.Unquote(k.Value)
+							names = append(names, name)
+						}
+					}
+				}
+			}
+		}
+		return false
+	})
+	util.TestAssert(t, len(names) > 0)
+	for _, name := range names {
+		if name == "_eval_args_" {
+			continue
+		}
+		if _, ok := escapers[name]; !ok {
+			t.Errorf("%s: no runtime equivalent for %q", runtime.Version(), name)
+		}
+	}
+}
+
+func TestUnknownEscaper(t *testing.T) {
+	tmpl := tt.New("test").Funcs(tt.FuncMap{
+		"_html_template_htmlescaper": dummyFn,
+		"_html_template_newescaper":  dummyFn,
+	})
+	tmpl = tt.Must(tmpl.Parse("{{. | _html_template_htmlescaper}}\n{{. | _html_template_newescaper}}"))
+	diags := &diagnostics{}
+	checkEscapers([]*parse.Tree{tmpl.Tree}, diags)
+	util.TestEq(t, len(diags.list), 1)
+	d := diags.list[0]
+	util.TestEq(t, d.String(), `test:2:7: error: unsupported html/template escaper "_html_template_newescaper" (tmtr `+Version+`, Go `+runtime.Version()+`)`)
+	diags = &diagnostics{}
+	checkEscapers([]*parse.Tree{tt.Must(tt.New("test").Parse("{{.}}")).Tree}, diags)
+	util.TestEq(t, len(diags.list), 0)
+}
@@ -1,10 +1,10 @@
 package gen
 
 import (
-	"fmt"
 	"go/ast"
 	"go/token"
-	"os"
+	"go/types"
+	"strconv"
 	"text/template/parse"
 
 	"github.com/apleshkov/tmtr/scopes"
@@ -13,182 +13,64 @@ import (
 
 func (g *Generator) nodeExpr(n parse.Node, scope scopes.Scope) ast.Expr {
 	if n, ok := n.(*parse.CommandNode); ok {
-		return g.cmdExpr(n, scope)
+		return g.cmdExpr(n, false, scope)
 	}
 	if n, ok := n.(*parse.IdentifierNode); ok {
 		s := n.Ident
 		switch s {
-		case "and":
-			return &ast.SelectorExpr{
-				X:   g.useFuncs(scope),
-				Sel: andIdent,
-			}
-		case "or":
-			return &ast.SelectorExpr{
-				X:   g.useFuncs(scope),
-				Sel: orIdent,
-			}
 		case "html":
-			return &ast.SelectorExpr{
-				X:   g.useHTMLTemplate(scope),
+			return g.typed(&ast.SelectorExpr{
+				X:   g.useHTMLTemplate(),
 				Sel: htmlEscaperIdent,
-			}
+			}, funcReturning(types.Typ[types.String]))
 		case "js":
-			return &ast.SelectorExpr{
-				X:   g.useHTMLTemplate(scope),
+			return g.typed(&ast.SelectorExpr{
+				X:   g.useHTMLTemplate(),
 				Sel: jsEscaperIdent,
-			}
+			}, funcReturning(types.Typ[types.String]))
 		case "not":
-			return &ast.SelectorExpr{
-				X:   g.useFuncs(scope),
+			return g.typed(&ast.SelectorExpr{
+				X:   g.useFuncs(),
 				Sel: isNotTrueIdent,
-			}
+			}, funcReturning(types.Typ[types.Bool]))
 		case "print", "_eval_args_":
-			return &ast.SelectorExpr{
-				X:   g.useFmt(scope),
+			return g.typed(&ast.SelectorExpr{
+				X:   g.useFmt(),
 				Sel: sprintIdent,
-			}
+			}, funcReturning(types.Typ[types.String]))
 		case "printf":
-			return &ast.SelectorExpr{
-				X:   g.useFmt(scope),
+			return g.typed(&ast.SelectorExpr{
+				X:   g.useFmt(),
 				Sel: sprintfIdent,
-			}
+			}, funcReturning(types.Typ[types.String]))
 		case "println":
-			return &ast.SelectorExpr{
-				X:   g.useFmt(scope),
+			return g.typed(&ast.SelectorExpr{
+				X:   g.useFmt(),
 				Sel: sprintlnIdent,
-			}
+			}, funcReturning(types.Typ[types.String]))
 		case "urlquery":
-			return &ast.SelectorExpr{
-				X:   g.useHTMLTemplate(scope),
+			return g.typed(&ast.SelectorExpr{
+				X:   g.useHTMLTemplate(),
 				Sel: urlQueryEscaperIdent,
-			}
-		case "_html_template_attrescaper":
-			return &ast.SelectorExpr{
-				X:   g.useFuncs(scope),
-				Sel: escapeHTMLAttrIdent,
-			}
-		case "_html_template_commentescaper":
-			return &ast.SelectorExpr{
-				X:   g.useFuncs(scope),
-				Sel: escapeCommentIdent,
-			}
-		case "_html_template_cssescaper":
-			return &ast.SelectorExpr{
-				X:   g.useFuncs(scope),
-				Sel: escapeCSSIdent,
-			}
-		case "_html_template_cssvaluefilter":
-			return &ast.SelectorExpr{
-				X:   g.useFuncs(scope),
-				Sel: filterCSSIdent,
-			}
-		case "_html_template_htmlnamefilter":
-			return &ast.SelectorExpr{
-				X:   g.useFuncs(scope),
-				Sel: filterHTMLTagContentIdent,
-			}
-		case "_html_template_htmlescaper":
-			return &ast.SelectorExpr{
-				X:   g.useFuncs(scope),
-				Sel: escapeHTMLIdent,
-			}
-		case "_html_template_jsregexpescaper":
-			return &ast.SelectorExpr{
-				X:   g.useFuncs(scope),
-				Sel: escapeJSRegexpIdent,
-			}
-		case "_html_template_jsstrescaper":
-			return &ast.SelectorExpr{
-				X:   g.useFuncs(scope),
-				Sel: escapeJSStrIdent,
-			}
-		case "_html_template_jstmpllitescaper":
-			return &ast.SelectorExpr{
-				X:   g.useFuncs(scope),
-				Sel: escapeJSTmplLitIdent,
-			}
-		case "_html_template_jsvalescaper":
-			return &ast.CallExpr{
-				Fun: &ast.SelectorExpr{
-					X:   g.useFuncs(scope),
-					Sel: escapeJSIdent,
-				},
-				Args: []ast.Expr{g.eoutIdent},
-			}
-		case "_html_template_nospaceescaper":
-			return &ast.SelectorExpr{
-				X:   g.useFuncs(scope),
-				Sel: escapeUnquotedHTMLAttrIdent,
-			}
-		case "_html_template_rcdataescaper":
-			return &ast.SelectorExpr{
-				X:   g.useFuncs(scope),
-				Sel: escapeRCDataIdent,
-			}
-		case "_html_template_srcsetescaper":
-			return &ast.CallExpr{
-				Fun: &ast.SelectorExpr{
-					X:   g.useFuncs(scope),
-					Sel: filterAndEscapeSrcsetIdent,
-				},
-				Args: []ast.Expr{g.eoutIdent},
-			}
-		case "_html_template_urlescaper":
-			return &ast.SelectorExpr{
-				X:   g.useFuncs(scope),
-				Sel: escapeURLIdent,
-			}
-		case "_html_template_urlfilter":
-			return &ast.CallExpr{
-				Fun: &ast.SelectorExpr{
-					X:   g.useFuncs(scope),
-					Sel: filterURLIdent,
-				},
-				Args: []ast.Expr{g.eoutIdent},
-			}
-		case "_html_template_urlnormalizer":
-			return &ast.SelectorExpr{
-				X:   g.useFuncs(scope),
-				Sel: normalizeURLIdent,
-			}
+			}, funcReturning(types.Typ[types.String]))
+		case "len":
+			return g.typed(ast.NewIdent(s), funcReturning(types.Typ[types.Int]))
 		default:
-			return ast.NewIdent(s)
+			if esc, ok := escapers[s]; ok {
+				return g.escaperExpr(esc, scope)
+			}
+			g.imports.useNamed(s)
+			return g.typed(ast.NewIdent(s), g.env.funcType(s))
 		}
 	}
 	if n, ok := n.(*parse.ChainNode); ok {
-		expr := g.nodeExpr(n.Node, scope)
-		for _, f := range n.Field {
-			expr = &ast.SelectorExpr{
-				X:   expr,
-				Sel: ast.NewIdent(f),
-			}
-		}
-		return expr
+		return g.chainExpr(n, false, scope)
 	}
 	if n, ok := n.(*parse.PipeNode); ok {
-		return g.cmdsExpr(n.Cmds, scope)
+		return g.cmdsExpr(n.Cmds, n, scope)
 	}
 	if n, ok := n.(*parse.VariableNode); ok {
-		idents := n.Ident
-		if len(idents) == 0 {
-			return &ast.BadExpr{}
-		}
-		var expr ast.Expr
-		name := idents[0]
-		if name == "$" {
-			expr = scope.Dollar()
-		} else {
-			name = util.TrimDollarPrefix(name)
-			expr = ast.NewIdent(name)
-		}
-		for _, s := range idents[1:] {
-			expr = &ast.SelectorExpr{
-				X:   expr,
-				Sel: ast.NewIdent(s),
-			}
-		}
-		return expr
+		return g.variableExpr(n, false, scope)
 	}
 	if _, ok := n.(*parse.DotNode); ok {
 		return scope.Dot()
@@ -197,139 +79,576 @@ func (g *Generator) nodeExpr(n parse.Node, scope scopes.Scope) ast.Expr {
 		return nilIdent
 	}
 	if n, ok := n.(*parse.FieldNode); ok {
-		var c ast.Expr = scope.Dot()
-		for _, s := range n.Ident {
-			c = &ast.SelectorExpr{
-				X:   c,
-				Sel: ast.NewIdent(s),
-			}
-		}
-		return c
+		return g.fieldsExpr(scope.Dot(), n.Ident, false, n, scope)
 	}
 	if n, ok := n.(*parse.BoolNode); ok {
-		return ast.NewIdent(n.String())
+		return g.typed(ast.NewIdent(n.String()), types.Typ[types.Bool])
 	}
 	if n, ok := n.(*parse.NumberNode); ok {
 		if n.IsInt {
-			return &ast.BasicLit{
+			return g.typed(&ast.BasicLit{
 				Kind:  token.INT,
 				Value: n.Text,
-			}
+			}, types.Typ[types.Int])
 		}
 		if n.IsFloat {
-			return &ast.BasicLit{
+			return g.typed(&ast.BasicLit{
 				Kind:  token.FLOAT,
 				Value: n.Text,
-			}
+			}, types.Typ[types.Float64])
 		}
 		if n.IsComplex {
-			return &ast.BasicLit{
+			return g.typed(&ast.BasicLit{
 				Kind:  token.IMAG,
 				Value: n.Text,
-			}
+			}, types.Typ[types.Complex128])
 		}
 	}
 	if n, ok := n.(*parse.StringNode); ok {
-		return &ast.BasicLit{
+		return g.typed(&ast.BasicLit{
 			Kind:  token.STRING,
 			Value: n.Quoted,
-		}
+		}, types.Typ[types.String])
 	}
-	fmt.Fprintf(os.Stderr, "[expr] unknown node: %s (type: %d)\n", n.String(), n.Type())
+	g.errorf(n, "unknown node: %s (type: %d)", n.String(), n.Type())
 	return &ast.BadExpr{}
 }
 
-func (g *Generator) indexExpr(args []parse.Node, scope scopes.Scope) ast.Expr {
-	if len(args) < 2 {
+// Same as `nodeExpr`, but the last method of a field chain isn't called,
+// cause it's a function to call with arguments (e.g. `{{.Method 1 2}}`).
+func (g *Generator) calleeExpr(n parse.Node, scope scopes.Scope) ast.Expr {
+	switch n := n.(type) {
+	case *parse.FieldNode:
+		return g.fieldsExpr(scope.Dot(), n.Ident, true, n, scope)
+	case *parse.ChainNode:
+		return g.chainExpr(n, true, scope)
+	case *parse.VariableNode:
+		return g.variableExpr(n, true, scope)
+	}
+	return g.nodeExpr(n, scope)
+}
+
+func (g *Generator) chainExpr(n *parse.ChainNode, callee bool, scope scopes.Scope) ast.Expr {
+	if id, ok := n.Node.(*parse.IdentifierNode); ok && g.env != nil {
+		// e.g. `strings.ToUpper`
+		if p, ok := g.env.imports[id.Ident]; ok && len(n.Field) == 1 {
+			var t types.Type
+			if obj := p.Scope().Lookup(n.Field[0]); obj != nil {
+				t = obj.Type()
+			}
+			return g.typed(&ast.SelectorExpr{
+				X:   g.nodeExpr(id, scope),
+				Sel: ast.NewIdent(n.Field[0]),
+			}, t)
+		}
+	}
+	return g.fieldsExpr(g.nodeExpr(n.Node, scope), n.Field, callee, n, scope)
+}
+
+func (g *Generator) variableExpr(n *parse.VariableNode, callee bool, scope scopes.Scope) ast.Expr {
+	idents := n.Ident
+	if len(idents) == 0 {
+		g.errorf(n, "empty variable")
 		return &ast.BadExpr{}
 	}
-	expr := &ast.IndexExpr{
-		X:     g.nodeExpr(args[0], scope),
-		Index: g.nodeExpr(args[1], scope),
+	var expr ast.Expr
+	name := idents[0]
+	if name == "$" {
+		expr = scope.Dollar()
+	} else {
+		name = util.TrimDollarPrefix(name)
+		if id := scopes.Lookup(scope, name); id != nil {
+			expr = id
+		} else {
+			expr = ast.NewIdent(name)
+		}
 	}
-	for _, a := range args[2:] {
-		expr = &ast.IndexExpr{
-			X:     expr,
-			Index: g.nodeExpr(a, scope),
+	return g.fieldsExpr(expr, idents[1:], callee, n, scope)
+}
+
+// Selects the fields (or calls the methods) of `x` one by one. The last
+// method isn't called if it's a `callee`.
+func (g *Generator) fieldsExpr(x ast.Expr, names []string, callee bool, at parse.Node, scope scopes.Scope) ast.Expr {
+	for i, name := range names {
+		x = g.selectorExpr(x, name, callee && i == len(names)-1, at, scope)
+	}
+	return x
+}
+
+// Returns `x.name` if it's a field, `x.name()` if it's a method, and
+// `x["name"]` if `x` is a map. The type of `x` is required to tell one from
+// another, so `x.name` is returned if it's unknown.
+func (g *Generator) selectorExpr(x ast.Expr, name string, callee bool, at parse.Node, scope scopes.Scope) ast.Expr {
+	sel := &ast.SelectorExpr{
+		X:   x,
+		Sel: ast.NewIdent(name),
+	}
+	t := g.typeOf(x)
+	if t == nil {
+		return sel
+	}
+	obj, _, _ := types.LookupFieldOrMethod(t, g.addressable(x), g.env.pkg, name)
+	_, isPtr := t.Underlying().(*types.Pointer)
+	switch obj := obj.(type) {
+	case *types.Var:
+		if isPtr {
+			g.nilGuard(x, t, name, at)
+		}
+		return g.typed(sel, obj.Type())
+	case *types.Func:
+		sig := obj.Type().(*types.Signature)
+		// The methods of pointer receivers could be called on nil
+		if _, ptrRecv := sig.Recv().Type().(*types.Pointer); isInterface(t) || isPtr && !ptrRecv {
+			g.nilGuard(x, t, name, at)
+		}
+		g.typed(sel, sig)
+		if callee {
+			return sel
+		}
+		return g.methodCallExpr(sel, sig, at, scope)
+	}
+	if m, ok := t.Underlying().(*types.Map); ok && hasStringKey(m) {
+		return g.mapKeyExpr(x, m, name)
+	}
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		if m, ok := p.Elem().Underlying().(*types.Map); ok && hasStringKey(m) {
+			g.nilGuard(x, t, name, at)
+			return g.mapKeyExpr(&ast.ParenExpr{X: &ast.StarExpr{X: x}}, m, name)
+		}
+	}
+	if isInterface(t) {
+		return g.dynamicFieldExpr(x, name, callee, scope)
+	}
+	g.errorf(at, "can't evaluate field %s in type %s", name, g.env.typeString(t))
+	return sel
+}
+
+func hasStringKey(m *types.Map) bool {
+	b, ok := m.Key().Underlying().(*types.Basic)
+	return ok && b.Info()&types.IsString != 0
+}
+
+// Returns `x["name"]`, so a missing key gives the zero value as the
+// `missingkey=default` option does.
+func (g *Generator) mapKeyExpr(x ast.Expr, m *types.Map, name string) ast.Expr {
+	return g.typed(&ast.IndexExpr{
+		X: x,
+		Index: &ast.BasicLit{
+			Kind:  token.STRING,
+			Value: strconv.Quote(name),
+		},
+	}, m.Elem())
+}
+
+// Returns `tmtr.Field(errOutput, x, "name")`, or `tmtr.Method(errOutput, x,
+// "name")` if it's a `callee` (the arguments are appended later).
+func (g *Generator) dynamicFieldExpr(x ast.Expr, name string, callee bool, scope scopes.Scope) ast.Expr {
+	fn := fieldIdent
+	if callee {
+		fn = methodIdent
+	}
+	return g.typed(&ast.CallExpr{
+		Fun: &ast.SelectorExpr{
+			X:   g.useFuncs(),
+			Sel: fn,
+		},
+		Args: []ast.Expr{
+			g.eoutIdent,
+			x,
+			&ast.BasicLit{
+				Kind:  token.STRING,
+				Value: strconv.Quote(name),
+			},
+		},
+	}, anyType)
+}
+
+// Calls the method without arguments.
+func (g *Generator) methodCallExpr(sel *ast.SelectorExpr, sig *types.Signature, at parse.Node, scope scopes.Scope) ast.Expr {
+	name := sel.Sel.Name
+	if n := sig.Params().Len(); n > 0 && !(sig.Variadic() && n == 1) {
+		g.errorf(at, "wrong number of args for %s: want %d got 0", name, n)
+		return sel
+	}
+	if returnsError(sig) {
+		// `x.Method` is `func() (T, error)`
+		return g.typed(&ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   g.useFuncs(),
+				Sel: maybeIdent,
+			},
+			Args: []ast.Expr{g.eoutIdent, sel},
+		}, resultType(sig))
+	}
+	if t := resultType(sig); t != nil {
+		return g.typed(&ast.CallExpr{Fun: sel}, t)
+	}
+	g.errorf(at, "can't call method %s with %d results", name, sig.Results().Len())
+	return sel
+}
+
+// Handles the error of a function returning a value and an error, e.g.
+// `tmtr.MayBe(errOutput, tmtr.Result(foo(x)))` for `foo(x)`. Other
+// expressions are returned as is.
+func (g *Generator) checkedExpr(x ast.Expr, scope scopes.Scope) ast.Expr {
+	call, ok := x.(*ast.CallExpr)
+	if !ok {
+		return x
+	}
+	sig := signatureOf(g.typeOf(call.Fun))
+	if sig == nil || !returnsError(sig) {
+		return x
+	}
+	return g.typed(&ast.CallExpr{
+		Fun: &ast.SelectorExpr{
+			X:   g.useFuncs(),
+			Sel: maybeIdent,
+		},
+		Args: []ast.Expr{
+			g.eoutIdent,
+			&ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   g.useFuncs(),
+					Sel: resultIdent,
+				},
+				Args: []ast.Expr{call},
+			},
+		},
+	}, resultType(sig))
+}
+
+func (g *Generator) indexExpr(args []parse.Node, at parse.Node, scope scopes.Scope) ast.Expr {
+	if len(args) < 1 {
+		g.errorf(at, "wrong number of args for index: want at least 1 got 0")
+		return &ast.BadExpr{}
+	}
+	var expr ast.Expr = g.nodeExpr(args[0], scope)
+	for i, a := range args[1:] {
+		if isInterface(g.typeOf(expr)) {
+			// e.g. `tmtr.Index(errOutput, x, 1, 2)`
+			call := &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   g.useFuncs(),
+					Sel: indexIdent,
+				},
+				Args: []ast.Expr{g.eoutIdent, expr},
+			}
+			for _, a := range args[(i + 1):] {
+				call.Args = append(call.Args, g.nodeExpr(a, scope))
+			}
+			return g.typed(call, anyType)
 		}
+		xt := g.typeOf(expr)
+		index := g.nodeExpr(a, scope)
+		g.indexGuard(expr, index, xt, at)
+		expr = g.typed(&ast.IndexExpr{
+			X:     expr,
+			Index: index,
+		}, indexType(xt))
 	}
 	return expr
 }
 
-func (g *Generator) sliceExpr(args []parse.Node, scope scopes.Scope) ast.Expr {
+func (g *Generator) sliceExpr(args []parse.Node, at parse.Node, scope scopes.Scope) ast.Expr {
 	if len(args) < 1 {
+		g.errorf(at, "wrong number of args for slice: want at least 1 got 0")
 		return &ast.BadExpr{}
 	}
 	x := g.nodeExpr(args[0], scope)
+	xt := g.typeOf(x)
+	t := sliceType(xt)
 	args = args[1:]
-	switch len(args) {
+	if len(args) > 3 {
+		g.errorf(at, "too many slice indexes: %d", len(args))
+		return &ast.BadExpr{}
+	}
+	idx := make([]ast.Expr, len(args))
+	for i, a := range args {
+		idx[i] = g.nodeExpr(a, scope)
+	}
+	g.sliceGuard(x, idx, xt, at)
+	switch len(idx) {
 	case 0:
-		return &ast.SliceExpr{X: x}
+		return g.typed(&ast.SliceExpr{X: x}, t)
 	case 1:
-		return &ast.SliceExpr{
+		return g.typed(&ast.SliceExpr{
 			X:   x,
-			Low: g.nodeExpr(args[0], scope),
-		}
+			Low: idx[0],
+		}, t)
 	case 2:
-		return &ast.SliceExpr{
+		return g.typed(&ast.SliceExpr{
 			X:    x,
-			Low:  g.nodeExpr(args[0], scope),
-			High: g.nodeExpr(args[1], scope),
+			Low:  idx[0],
+			High: idx[1],
+		}, t)
+	}
+	return g.typed(&ast.SliceExpr{
+		X:      x,
+		Low:    idx[0],
+		High:   idx[1],
+		Max:    idx[2],
+		Slice3: true,
+	}, t)
+}
+
+// Compares the arguments of `eq`, `ne`, `lt`, etc. by the operator.
+func (g *Generator) binExpr(name string, op token.Token, args []parse.Node, at parse.Node, scope scopes.Scope) ast.Expr {
+	if op == token.EQL && len(args) < 2 || op != token.EQL && len(args) != 2 {
+		g.errorf(at, "wrong number of args for %s: want 2 got %d", name, len(args))
+		return &ast.BadExpr{}
+	}
+	xs := make([]ast.Expr, len(args))
+	for i, a := range args {
+		xs[i] = g.nodeExpr(a, scope)
+	}
+	if !g.comparable(op, xs) {
+		// text/template compares the values of the basic kinds (e.g. `int`
+		// and `uint8`) and of the interfaces, so it's done at runtime
+		return g.typed(&ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   g.useFuncs(),
+				Sel: cmpIdents[op],
+			},
+			Args: append([]ast.Expr{g.eoutIdent}, xs...),
+		}, types.Typ[types.Bool])
+	}
+	var res ast.Expr
+	for _, y := range xs[1:] {
+		cmp := &ast.BinaryExpr{
+			Op: op,
+			X:  xs[0],
+			Y:  y,
 		}
-	case 3:
-		return &ast.SliceExpr{
-			X:      x,
-			Low:    g.nodeExpr(args[0], scope),
-			High:   g.nodeExpr(args[1], scope),
-			Max:    g.nodeExpr(args[2], scope),
-			Slice3: true,
+		if res != nil {
+			res = &ast.BinaryExpr{
+				Op: token.LOR,
+				X:  res,
+				Y:  cmp,
+			}
+		} else {
+			res = cmp
 		}
-	default:
-		return &ast.BadExpr{}
 	}
+	return g.typed(res, types.Typ[types.Bool])
 }
 
-func (g *Generator) binExpr(op token.Token, args []parse.Node, scope scopes.Scope) ast.Expr {
-	if op == token.EQL && len(args) > 2 {
-		x := g.nodeExpr(args[0], scope)
-		var prev ast.Expr
-		for _, a := range args[1:] {
-			eq := &ast.BinaryExpr{
-				Op: op,
-				X:  x,
-				Y:  g.nodeExpr(a, scope),
-			}
-			if prev != nil {
-				prev = &ast.BinaryExpr{
-					Op: token.LOR,
-					X:  prev,
-					Y:  eq,
-				}
-			} else {
-				prev = eq
-			}
+var cmpIdents = map[token.Token]*ast.Ident{
+	token.EQL: eqIdent,
+	token.NEQ: neIdent,
+	token.LSS: ltIdent,
+	token.LEQ: leIdent,
+	token.GTR: gtIdent,
+	token.GEQ: geIdent,
+}
+
+// Reports if the values could be compared by the Go operator, i.e. they're
+// of the same (non-interface) type, and the constants are assignable to it.
+// Otherwise the types don't line up (e.g. `int` and `uint8`, or unknown
+// ones), and the operator either doesn't compile or differs from
+// text/template.
+func (g *Generator) comparable(op token.Token, xs []ast.Expr) bool {
+	var t types.Type
+	for _, x := range xs {
+		if isConstLit(x) {
+			continue
 		}
-		return prev
+		xt := g.valueType(x)
+		if xt == nil || t != nil && !types.Identical(t, xt) {
+			return false
+		}
+		t = xt
+	}
+	if t == nil {
+		// Constants only (e.g. `{{eq 1 2}}`)
+		t = g.valueType(xs[0])
+		if t == nil {
+			return false
+		}
+	}
+	for _, x := range xs {
+		if isConstLit(x) && !g.assignableTo(x, t) {
+			return false
+		}
+	}
+	if types.IsInterface(t) {
+		return false
+	}
+	if op == token.EQL || op == token.NEQ {
+		return types.Comparable(t)
+	}
+	return isOrdered(t)
+}
+
+func isConstLit(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.BasicLit:
+		return true
+	case *ast.Ident:
+		return x.Name == "true" || x.Name == "false"
 	}
-	if len(args) != 2 {
+	return false
+}
+
+// Evaluates the arguments lazily like text/template does, so `{{and .User
+// .User.IsAdmin}}` is fine with a nil user. The piped value (if any) is the
+// last argument. Booleans are just `a && b`, and the rest are wrapped in a
+// closure returning the first falsy (or truthy for `or`) argument or the
+// last one:
+//
+//	func() any {
+//		if v := data.User; tmtr.IsNotTrue(v) {
+//			return v
+//		}
+//		return data.User.IsAdmin
+//	}()
+func (g *Generator) andOrExpr(name string, args []parse.Node, piped ast.Expr, at parse.Node, scope scopes.Scope) ast.Expr {
+	xs := make([]ast.Expr, 0, len(args)+1)
+	// The safe mode's checks of the lazily evaluated arguments are made
+	// only if they're evaluated (see `guardedStmts`)
+	guards := make([][]ast.Expr, len(args)+1)
+	guarded := false
+	for i, a := range args {
+		if i == 0 {
+			xs = append(xs, g.nodeExpr(a, scope))
+			continue
+		}
+		outer := g.guards
+		g.guards = nil
+		xs = append(xs, g.nodeExpr(a, scope))
+		guards[i], g.guards = g.guards, outer
+		guarded = guarded || len(guards[i]) > 0
+	}
+	if piped != nil {
+		xs = append(xs, piped)
+	}
+	if len(xs) == 0 {
+		g.errorf(at, "wrong number of args for %s: want at least 1 got 0", name)
 		return &ast.BadExpr{}
 	}
-	return &ast.BinaryExpr{
-		Op: op,
-		X:  g.nodeExpr(args[0], scope),
-		Y:  g.nodeExpr(args[1], scope),
+	if len(xs) == 1 {
+		return xs[0]
+	}
+	t := g.valueType(xs[0])
+	for _, x := range xs[1:] {
+		if xt := g.valueType(x); t != nil && (xt == nil || !types.Identical(t, xt)) {
+			t = nil
+		}
 	}
+	op, cond := token.LAND, isNotTrueIdent
+	if name == "or" {
+		op, cond = token.LOR, isTrueIdent
+	}
+	if b, ok := underlyingBasic(t); ok && b.Info()&types.IsBoolean != 0 {
+		res := parenExpr(xs[0])
+		for i, x := range xs[1:] {
+			x = parenExpr(x)
+			if gs := guards[i+1]; len(gs) > 0 {
+				// e.g. `data.Flag || (tmtr.NotNil(...) && data.User.Admin)`
+				x = &ast.ParenExpr{X: &ast.BinaryExpr{X: guardsExpr(gs), Op: token.LAND, Y: x}}
+			}
+			res = &ast.BinaryExpr{X: res, Op: op, Y: x}
+		}
+		return g.typed(res, t)
+	}
+	if t == nil || g.typeExpr(t) == nil || guarded {
+		// A failed check gives nil
+		t = anyType
+	}
+	body := make([]ast.Stmt, 0, len(xs))
+	for i, x := range xs {
+		if gs := guards[i]; len(gs) > 0 {
+			body = append(body, &ast.IfStmt{
+				Cond: &ast.UnaryExpr{Op: token.NOT, X: parenExpr(guardsExpr(gs))},
+				Body: &ast.BlockStmt{
+					List: []ast.Stmt{
+						&ast.ReturnStmt{Results: []ast.Expr{nilIdent}},
+					},
+				},
+			})
+		}
+		if i == len(xs)-1 {
+			body = append(body, &ast.ReturnStmt{Results: []ast.Expr{x}})
+			break
+		}
+		v := ast.NewIdent("v")
+		body = append(body, &ast.IfStmt{
+			Init: &ast.AssignStmt{
+				Tok: token.DEFINE,
+				Lhs: []ast.Expr{v},
+				Rhs: []ast.Expr{x},
+			},
+			Cond: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   g.useFuncs(),
+					Sel: cond,
+				},
+				Args: []ast.Expr{v},
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.ReturnStmt{Results: []ast.Expr{v}},
+				},
+			},
+		})
+	}
+	return g.typed(&ast.CallExpr{
+		Fun: &ast.FuncLit{
+			Type: &ast.FuncType{
+				Params: &ast.FieldList{},
+				Results: &ast.FieldList{
+					List: []*ast.Field{{Type: g.typeExpr(t)}},
+				},
+			},
+			Body: &ast.BlockStmt{List: body},
+		},
+	}, t)
 }
 
-func (g *Generator) maybeExpr(args []parse.Node, scope scopes.Scope) ast.Expr {
+func isAndOr(name string) bool {
+	return name == "and" || name == "or"
+}
+
+// Parenthesizes the `&&` and `||` operands, so they're printed as is.
+func parenExpr(x ast.Expr) ast.Expr {
+	if b, ok := x.(*ast.BinaryExpr); ok && (b.Op == token.LAND || b.Op == token.LOR) {
+		return &ast.ParenExpr{X: x}
+	}
+	return x
+}
+
+func (g *Generator) maybeExpr(args []parse.Node, at parse.Node, scope scopes.Scope) ast.Expr {
 	res := make([]ast.Expr, 0)
 	if len(args) > 0 {
-		expr := g.nodesExpr(args, scope)
+		var expr ast.Expr
+		if len(args) == 1 && g.env != nil {
+			expr = g.calleeExpr(args[0], scope)
+			if sig := signatureOf(g.typeOf(expr)); sig != nil {
+				if sig.Params().Len() == 0 && returnsError(sig) {
+					// e.g. `tmtr.MayBe(errOutput, data.Method)`
+					return g.typed(&ast.CallExpr{
+						Fun: &ast.SelectorExpr{
+							X:   g.useFuncs(),
+							Sel: maybeIdent,
+						},
+						Args: []ast.Expr{g.eoutIdent, expr},
+					}, resultType(sig))
+				}
+				expr = g.typed(&ast.CallExpr{Fun: expr}, resultType(sig))
+			}
+		} else {
+			expr = g.nodesExpr(args, at, scope)
+		}
+		if call, ok := expr.(*ast.CallExpr); ok {
+			if x := g.checkedExpr(call, scope); x != expr {
+				// e.g. `tmtr.MayBe(errOutput, tmtr.Result(foo(data)))`
+				return x
+			}
+		}
 		res = append(res, expr)
 	}
 	return &ast.CallExpr{
 		Fun: &ast.SelectorExpr{
-			X:   g.useFuncs(scope),
+			X:   g.useFuncs(),
 			Sel: maybeIdent,
 		},
 		Args: []ast.Expr{
@@ -355,41 +674,26 @@ func (g *Generator) maybeExpr(args []parse.Node, scope scopes.Scope) ast.Expr {
 	}
 }
 
-func (g *Generator) nodesExpr(nodes []parse.Node, scope scopes.Scope) ast.Expr {
+// `at` is used to position diagnostics.
+func (g *Generator) nodesExpr(nodes []parse.Node, at parse.Node, scope scopes.Scope) ast.Expr {
+	if id, ok := nodes[0].(*parse.IdentifierNode); ok && isAndOr(id.Ident) {
+		return g.andOrExpr(id.Ident, nodes[1:], nil, at, scope)
+	}
 	if len(nodes) > 1 {
 		var root, curr *ast.CallExpr
 		for i, a := range nodes {
-			expr := g.nodeExpr(a, scope)
+			var expr ast.Expr
+			if root == nil {
+				expr = g.calleeExpr(a, scope)
+			} else {
+				expr = g.nodeExpr(a, scope)
+			}
 			if id, ok := expr.(*ast.Ident); ok {
 				if id.Name == "call" {
 					continue
 				}
-				if id.Name == "index" {
-					return g.indexExpr(nodes[(i+1):], scope)
-				}
-				if id.Name == "slice" {
-					return g.sliceExpr(nodes[(i+1):], scope)
-				}
-				if id.Name == "eq" {
-					return g.binExpr(token.EQL, nodes[(i+1):], scope)
-				}
-				if id.Name == "ne" {
-					return g.binExpr(token.NEQ, nodes[(i+1):], scope)
-				}
-				if id.Name == "lt" {
-					return g.binExpr(token.LSS, nodes[(i+1):], scope)
-				}
-				if id.Name == "le" {
-					return g.binExpr(token.LEQ, nodes[(i+1):], scope)
-				}
-				if id.Name == "gt" {
-					return g.binExpr(token.GTR, nodes[(i+1):], scope)
-				}
-				if id.Name == "ge" {
-					return g.binExpr(token.GEQ, nodes[(i+1):], scope)
-				}
-				if id.Name == "maybe" {
-					return g.maybeExpr(nodes[(i+1):], scope)
+				if b := g.builtinExpr(id.Name, nodes[(i+1):], at, scope); b != nil {
+					return b
 				}
 			}
 			if root == nil {
@@ -409,28 +713,94 @@ func (g *Generator) nodesExpr(nodes []parse.Node, scope scopes.Scope) ast.Expr {
 				}
 			}
 		}
-		return root
+		return g.callExpr(root, scope)
 	}
 	if len(nodes) == 1 {
+		if id, ok := nodes[0].(*parse.IdentifierNode); ok {
+			if b := g.builtinExpr(id.Ident, nil, at, scope); b != nil {
+				return b
+			}
+		}
 		return g.nodeExpr(nodes[0], scope)
 	}
+	g.errorf(at, "empty command")
 	return &ast.BadExpr{}
 }
 
-func (g *Generator) cmdExpr(cmd *parse.CommandNode, scope scopes.Scope) ast.Expr {
+// Types the call by its function's result. The `len` builtin of an
+// interface value becomes `tmtr.Len(errOutput, x)`.
+func (g *Generator) callExpr(call *ast.CallExpr, scope scopes.Scope) ast.Expr {
+	if id, ok := call.Fun.(*ast.Ident); ok && id.Name == "len" && len(call.Args) == 1 {
+		if isInterface(g.typeOf(call.Args[0])) {
+			call.Fun = &ast.SelectorExpr{
+				X:   g.useFuncs(),
+				Sel: lenIdent,
+			}
+			call.Args = append([]ast.Expr{g.eoutIdent}, call.Args...)
+			return g.typed(call, types.Typ[types.Int])
+		}
+	}
+	return g.typed(call, resultType(signatureOf(g.typeOf(call.Fun))))
+}
+
+// Returns nil if there's no such builtin function requiring special handling.
+func (g *Generator) builtinExpr(name string, args []parse.Node, at parse.Node, scope scopes.Scope) ast.Expr {
+	switch name {
+	case "index":
+		return g.indexExpr(args, at, scope)
+	case "slice":
+		return g.sliceExpr(args, at, scope)
+	case "eq":
+		return g.binExpr(name, token.EQL, args, at, scope)
+	case "ne":
+		return g.binExpr(name, token.NEQ, args, at, scope)
+	case "lt":
+		return g.binExpr(name, token.LSS, args, at, scope)
+	case "le":
+		return g.binExpr(name, token.LEQ, args, at, scope)
+	case "gt":
+		return g.binExpr(name, token.GTR, args, at, scope)
+	case "ge":
+		return g.binExpr(name, token.GEQ, args, at, scope)
+	case "maybe":
+		return g.maybeExpr(args, at, scope)
+	}
+	return nil
+}
+
+// A `piped` command gets the previous command's result as the last
+// argument, so its single method isn't called (e.g. `{{1 | .Method}}`).
+func (g *Generator) cmdExpr(cmd *parse.CommandNode, piped bool, scope scopes.Scope) ast.Expr {
 	args := cmd.Args
 	if len(args) == 0 {
-		fmt.Fprintf(os.Stderr, "[expr] empty command: %s\n", cmd.String())
+		g.errorf(cmd, "empty command")
 		return &ast.BadExpr{}
 	}
-	return g.nodesExpr(args, scope)
+	if piped && len(args) == 1 {
+		switch args[0].(type) {
+		case *parse.FieldNode, *parse.ChainNode, *parse.VariableNode:
+			return g.calleeExpr(args[0], scope)
+		}
+	}
+	return g.nodesExpr(args, cmd, scope)
 }
 
-func (g *Generator) cmdsExpr(cmds []*parse.CommandNode, scope scopes.Scope) ast.Expr {
+// `at` is used to position diagnostics.
+func (g *Generator) cmdsExpr(cmds []*parse.CommandNode, at parse.Node, scope scopes.Scope) ast.Expr {
 	if len(cmds) > 1 {
 		var prev ast.Expr
 		for _, cmd := range cmds {
-			expr := g.cmdExpr(cmd, scope)
+			if esc, ok := pipedEscaper(cmd); ok && prev != nil {
+				if x := g.typedEscapeExpr(esc, prev, scope); x != nil {
+					prev = x
+					continue
+				}
+			}
+			if id, ok := cmd.Args[0].(*parse.IdentifierNode); ok && isAndOr(id.Ident) && prev != nil {
+				prev = g.checkedExpr(g.andOrExpr(id.Ident, cmd.Args[1:], prev, cmd, scope), scope)
+				continue
+			}
+			expr := g.cmdExpr(cmd, prev != nil, scope)
 			if prev != nil {
 				if call, ok := expr.(*ast.CallExpr); ok {
 					call.Args = append(call.Args, prev)
@@ -440,14 +810,15 @@ func (g *Generator) cmdsExpr(cmds []*parse.CommandNode, scope scopes.Scope) ast.
 						Args: []ast.Expr{prev},
 					}
 				}
+				expr = g.callExpr(expr.(*ast.CallExpr), scope)
 			}
-			prev = expr
+			prev = g.checkedExpr(expr, scope)
 		}
 		return prev
 	}
 	if len(cmds) == 1 {
-		return g.cmdExpr(cmds[0], scope)
+		return g.checkedExpr(g.cmdExpr(cmds[0], false, scope), scope)
 	}
-	fmt.Fprintln(os.Stderr, "[expr] empty commands")
+	g.errorf(at, "missing value for command")
 	return &ast.BadExpr{}
 }
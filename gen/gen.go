@@ -1,16 +1,24 @@
 package gen
 
 import (
+	"errors"
 	"fmt"
 	"go/ast"
+	"go/types"
 	ht "html/template"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
 	"slices"
 	"strings"
 	tt "text/template"
 	"text/template/parse"
+	"unicode"
+	"unicode/utf8"
+	"unsafe"
 
 	"github.com/apleshkov/tmtr/scopes"
 )
@@ -24,62 +32,408 @@ const (
 	ModeHTML
 )
 
+// Which panics are recovered (see `recoverStmts`).
+type RecoverMode int
+
+const (
+	RecoverNone   RecoverMode = iota
+	RecoverFunc               // in the generated functions
+	RecoverAction             // also in the actions, so the rendering goes on
+)
+
 type NamedTemplateInfo struct {
 	Name, DataType string
 }
 
 type GeneratorOptions struct {
-	InFile, OutFile string
-	Mode            Mode
-	Package         string
-	FnName          string
-	DataType        string
-	Tmpls           []NamedTemplateInfo
-	Imports         []string
-	Funcs           []string
+	// The template files (see `GenerateFromFile`)
+	InFiles []string
+	OutFile string
+	Mode    Mode
+	Package string
+	FnName  string
+	// The function names of the layout's templates and pages by their
+	// names, which override the generated ones
+	FnNames    map[string]string
+	DataType   string
+	Tmpls      []NamedTemplateInfo
+	Imports    []string
+	Funcs      []string
+	DiagFormat DiagFormat
+	// Emit `//line` directives pointing at the template
+	LineDirectives bool
+	// Comment statements with their template snippets
+	Comments bool
+	// Type-check the generated code (see `TypeCheck`)
+	TypeCheck bool
+	// Resolve the data types using the output file's package, so fields are
+	// distinguished from methods, etc. Falls back to the untyped mode if
+	// failed.
+	LoadTypes bool
+	// Range over maps in the sorted key order like text/template does
+	// (requires the types). Go's random order is used otherwise.
+	SortMaps bool
+	// Check the nil pointers of field chains and the bounds of `index` and
+	// `slice` (requires the types), so the failed actions write the errors
+	// to `errOutput` instead of panicking (see `guardedStmts`)
+	Safe bool
+	// Recover the panics and write them to `errOutput`
+	Recover RecoverMode
+	// Generate `func RenderX(output io.Writer, data T) error` functions,
+	// which stop at the first error and return it like `Template.Execute`
+	// does, instead of writing the errors to `errOutput`
+	ReturnErrors bool
+	// Wrap the output, so it stops at the first failed write: the error is
+	// written to `errOutput` once, and the loops exit early (see
+	// `stickyOutputStmt`)
+	StickyOutput bool
 }
 
 type Generator struct {
 	mode      Mode
+	tree      *parse.Tree
+	src       string
+	diags     *diagnostics
 	outIdent  *ast.Ident
 	dataIdent *ast.Ident
+	dataType  string
 	eoutIdent *ast.Ident
 	imports   *imports
 	usedTmpls map[string]bool
+	// the function arguments of the external templates, which are
+	// declared in the function's scope (see `tmplParam`)
+	tmplParams map[string]*ast.Ident
+	fnScope    scopes.Scope
+	// function names of the templates defined in the same input
+	tmpls map[string]string
+	// the blocks each function of a defined template calls, and the
+	// overrides to pass (see `blockOverrides`): the function arguments or
+	// the page's functions if `staticBlocks`
+	tmplBlocks   map[string][]string
+	overrides    map[string]ast.Expr
+	staticBlocks bool
+	env          *typeEnv // nil if the types are unknown
+	exprTypes    map[ast.Expr]types.Type
+	// the stack of the ranges being generated: true if it's a dynamic one
+	// (see `dynamicRangeStmt`)
+	ranges   []bool
+	sortMaps bool
+	safe     bool
+	// the checks of the action being generated in the safe mode (see
+	// `guardedStmts`)
+	guards []ast.Expr
+	// the position of the action being executed if the panics are
+	// recovered (see `recoverStmts`)
+	recoverMode RecoverMode
+	posIdent    *ast.Ident
+	// the functions return the first error (see `executeBody`)
+	returnErrors bool
+	// the output parameter if it's wrapped, and `outIdent` is the wrapped
+	// one (see `stickyOutputStmt`)
+	outParam *ast.Ident
+	// the declared variables, their values and the types of the ones
+	// reassigned with values of different types (see `widenVars`)
+	varDecls   map[*ast.Ident]*parse.VariableNode
+	varAssigns map[*parse.VariableNode][]ast.Expr
+	varTypes   map[*parse.VariableNode]types.Type
+
+	lineDirectives, comments bool
 }
 
-func GenerateFromFile(opts GeneratorOptions) (*ast.File, error) {
-	file := opts.InFile
-	if bytes, err := os.ReadFile(file); err != nil {
-		return nil, err
-	} else {
-		name := filepath.Base(file)
-		text := string(bytes)
-		return generateFromText(name, text, opts)
+// Returns the generated file and all the diagnostics (e.g. warnings). The
+// error is `Diagnostics` if there're errors among them.
+//
+// The input files are parsed into one set in the given order like
+// `ParseFiles` does, so the later `{{define}}`s override the earlier ones. A
+// file redefining the templates of the previous ones is a page: it's layered
+// over the rest (the layout) and gets its own function rendering the layout
+// with the page's templates (e.g. `RenderIndexAbout` for "about.html"). The
+// pages don't see each other's templates.
+func GenerateFromFile(opts GeneratorOptions) (*ast.File, Diagnostics, error) {
+	if len(opts.InFiles) == 0 {
+		return nil, nil, errors.New("no input files")
 	}
+	files := make([]inputFile, 0, len(opts.InFiles))
+	paths := make(map[string]string)
+	for _, path := range opts.InFiles {
+		bytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		// The templates are named after the files like `ParseFiles` does
+		name := filepath.Base(path)
+		if p, ok := paths[name]; ok {
+			return nil, nil, fmt.Errorf("%s and %s conflict: both templates are named %q", p, path, name)
+		}
+		paths[name] = path
+		files = append(files, inputFile{name: name, text: string(bytes)})
+	}
+	diags := &diagnostics{}
+	var env *typeEnv
+	if opts.LoadTypes {
+		env = loadTypeEnv(opts, diags)
+	}
+	f, ds, err := generateFromFiles(files, opts, env)
+	return f, append(diags.list, ds...), err
 }
 
-func generateFromText(name, text string, opts GeneratorOptions) (*ast.File, error) {
-	if root, all, err := parseText(name, text, opts); err != nil {
-		return nil, err
-	} else {
-		return generateFile(root, all, opts), nil
+// The `env` is nil in the untyped mode.
+func generateFromText(name, text string, opts GeneratorOptions, env *typeEnv) (*ast.File, Diagnostics, error) {
+	return generateFromFiles([]inputFile{{name: name, text: text}}, opts, env)
+}
+
+func generateFromFiles(files []inputFile, opts GeneratorOptions, env *typeEnv) (*ast.File, Diagnostics, error) {
+	root, all, err := parseFiles(files, opts, env)
+	if err != nil {
+		err = errorDiagnostics(err)
+		ds, _ := err.(Diagnostics)
+		return nil, ds, err
+	}
+	diags := &diagnostics{}
+	if opts.Mode == ModeHTML {
+		trees := make([]*parse.Tree, len(all))
+		for i, w := range all {
+			trees[i] = w.tree
+		}
+		checkEscapers(trees, diags)
+	}
+	f := generateFile(root, all, opts, env, diags)
+	if diags.list.HasErrors() {
+		return nil, diags.list, diags.list
 	}
+	return f, diags.list, nil
 }
 
-func parseText(name, text string, opts GeneratorOptions) (*tmplWrapper, []*tmplWrapper, error) {
+// The name is the one of the file's template (i.e. the base name).
+type inputFile struct {
+	name, text string
+}
+
+// A page parsed over the layout.
+type page struct {
+	name, text string
+	// the templates the page defines (except its own one, which is ignored
+	// like `Execute` of a `ParseFiles` set does)
+	defs []string
+	// the page's set along with the layout's templates
+	trees map[string]*parse.Tree
+}
+
+func parseFiles(files []inputFile, opts GeneratorOptions, env *typeEnv) (*tmplWrapper, []*tmplWrapper, error) {
+	layout, pages, err := splitPages(files, opts, env)
+	if err != nil {
+		return nil, nil, err
+	}
+	var trees map[string]*parse.Tree
 	switch opts.Mode {
 	case ModeText:
-		return parseTextTemplate(name, text, opts)
+		trees, err = parseTextTemplate(layout, pages, opts, env)
 	case ModeHTML:
-		return parseHTMLTemplate(name, text, opts)
+		trees, err = parseHTMLTemplate(layout, pages, opts, env)
+	default:
+		err = fmt.Errorf("parsing failed, unknown generator mode: %d", opts.Mode)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	srcs := make(map[string]string, len(files))
+	fileNames := make(map[string]bool, len(files))
+	for _, f := range files {
+		srcs[f.name] = f.text
+		fileNames[f.name] = true
 	}
-	return nil, nil, fmt.Errorf("parsing failed, unknown generator mode: %d", opts.Mode)
+	root, all, err := wrapTmpls(layout[0].name, trees, pages, fileNames, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, w := range all {
+		// The trees are named after the files they're parsed from
+		w.src = srcs[w.tree.ParseName]
+	}
+	return root, all, nil
+}
+
+// Returns the layout files and the pages (see `GenerateFromFile`). The
+// files are parsed alone to find out what they define.
+func splitPages(files []inputFile, opts GeneratorOptions, env *typeEnv) (layout []inputFile, pages []*page, err error) {
+	defined := make(map[string]bool)
+	for _, f := range files {
+		tmpl := tt.New(f.name)
+		addDummyFuncs(opts, env, func(fm tt.FuncMap) { tmpl.Funcs(fm) })
+		if _, err := tmpl.Parse(f.text); err != nil {
+			return nil, nil, err
+		}
+		defs := make([]string, 0)
+		redefines := false
+		for _, t := range tmpl.Templates() {
+			if tn := t.Name(); tn != f.name {
+				defs = append(defs, tn)
+				redefines = redefines || defined[tn]
+			}
+		}
+		if redefines {
+			slices.Sort(defs)
+			pages = append(pages, &page{name: f.name, text: f.text, defs: defs})
+			continue
+		}
+		layout = append(layout, f)
+		defined[f.name] = true
+		for _, tn := range defs {
+			defined[tn] = true
+		}
+	}
+	return layout, pages, nil
+}
+
+// Parses the layout and each page over its clone, and returns the layout's
+// trees.
+func parseTextTemplate(layout []inputFile, pages []*page, opts GeneratorOptions, env *typeEnv) (map[string]*parse.Tree, error) {
+	tmpl := tt.New(layout[0].name)
+	addDummyFuncs(opts, env, func(fm tt.FuncMap) { tmpl.Funcs(fm) })
+	for _, f := range layout {
+		t := tmpl
+		if f.name != tmpl.Name() {
+			t = tmpl.New(f.name)
+		}
+		if _, err := t.Parse(f.text); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range pages {
+		set, err := tmpl.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := set.New(p.name).Parse(p.text); err != nil {
+			return nil, err
+		}
+		p.trees = textTrees(set)
+	}
+	return textTrees(tmpl), nil
+}
+
+func textTrees(tmpl *tt.Template) map[string]*parse.Tree {
+	trees := make(map[string]*parse.Tree)
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			trees[t.Name()] = t.Tree
+		}
+	}
+	return trees
+}
+
+// The HTML sets are cloned before escaping, cause it's impossible after
+// it. The trees are escaped in place, so they're collected before it, and
+// then the ones derived for the other contexts are added (see
+// `derivedTrees`).
+func parseHTMLTemplate(layout []inputFile, pages []*page, opts GeneratorOptions, env *typeEnv) (map[string]*parse.Tree, error) {
+	tmpl := ht.New(layout[0].name)
+	addDummyFuncs(opts, env, func(fm tt.FuncMap) { tmpl.Funcs(fm) })
+	for _, f := range layout {
+		t := tmpl
+		if f.name != tmpl.Name() {
+			t = tmpl.New(f.name)
+		}
+		if _, err := t.Parse(f.text); err != nil {
+			return nil, err
+		}
+	}
+	sets := make([]*ht.Template, len(pages))
+	for i, p := range pages {
+		set, err := tmpl.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := set.New(p.name).Parse(p.text); err != nil {
+			return nil, err
+		}
+		p.trees = htmlTrees(set)
+		sets[i] = set
+	}
+	trees := htmlTrees(tmpl)
+	if err := escapeAndDerive(tmpl, trees, opts); err != nil {
+		return nil, err
+	}
+	for i, p := range pages {
+		if err := escapeAndDerive(sets[i], p.trees, opts); err != nil {
+			return nil, err
+		}
+	}
+	return trees, nil
+}
+
+// Escapes the set and adds the derived trees of the templates it has.
+func escapeAndDerive(tmpl *ht.Template, trees map[string]*parse.Tree, opts GeneratorOptions) error {
+	if err := escapeHTMLTemplate(tmpl, opts); err != nil {
+		return err
+	}
+	derived, err := derivedTrees(tmpl)
+	if err != nil {
+		return err
+	}
+	for name, tree := range derived {
+		// Skip the external and undefined ones
+		if _, ok := trees[unmangleTmplName(name)]; ok {
+			trees[name] = tree
+		}
+	}
+	return nil
+}
+
+// html/template escapes a called template in the context of the call, so
+// it derives a template for each non-text context (e.g.
+// "foo$htmltemplate_stateAttr_delimDoubleQuote") and renames the calls. The
+// derived ones are added to the underlying text/template set only, which is
+// the private `text` field, so it's read by reflection and `unsafe`.
+//
+// It depends on the html/template of the toolchain tmtr is built with, so
+// the field is checked up front (see `htmlTextField`), and the generation
+// fails if a Go release renames or retypes it.
+func derivedTrees(tmpl *ht.Template) (map[string]*parse.Tree, error) {
+	field, err := htmlTextField(reflect.TypeFor[ht.Template]())
+	if err != nil {
+		return nil, err
+	}
+	f := reflect.ValueOf(tmpl).Elem().FieldByIndex(field.Index)
+	text := reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem().Interface().(*tt.Template)
+	trees := make(map[string]*parse.Tree)
+	if text == nil {
+		return trees, nil
+	}
+	for _, t := range text.Templates() {
+		if name := t.Name(); isDerivedTmplName(name) && t.Tree != nil {
+			trees[name] = t.Tree
+		}
+	}
+	return trees, nil
+}
+
+// Returns the `text *template.Template` field of the html/template's
+// `Template` type (see `derivedTrees`).
+func htmlTextField(t reflect.Type) (reflect.StructField, error) {
+	f, ok := t.FieldByName("text")
+	if !ok || f.Type != reflect.TypeFor[*tt.Template]() {
+		return f, fmt.Errorf(
+			"html/template of %s: %s has no `text *template.Template` field, so the templates derived for the contexts of the calls can't be accessed",
+			runtime.Version(), t,
+		)
+	}
+	return f, nil
+}
+
+func htmlTrees(tmpl *ht.Template) map[string]*parse.Tree {
+	trees := make(map[string]*parse.Tree)
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			trees[t.Name()] = t.Tree
+		}
+	}
+	return trees
 }
 
 var dummyFn = func(...any) string { return "" }
 
-func addDummyFuncs(opts GeneratorOptions, cb func(tt.FuncMap)) {
+func addDummyFuncs(opts GeneratorOptions, env *typeEnv, cb func(tt.FuncMap)) {
 	fm := make(tt.FuncMap)
 	if opts.Funcs != nil {
 		for _, n := range opts.Funcs {
@@ -87,79 +441,140 @@ func addDummyFuncs(opts GeneratorOptions, cb func(tt.FuncMap)) {
 		}
 	}
 	if opts.Imports != nil {
-		for _, n := range opts.Imports {
-			if i := strings.LastIndex(n, "/"); i != -1 {
-				n = n[(i + 1):]
-			}
-			fm[n] = dummyFn
+		for _, path := range opts.Imports {
+			fm[env.importName(path)] = dummyFn
 		}
 	}
 	fm["maybe"] = dummyFn
 	cb(fm)
 }
 
-func parseTextTemplate(name, text string, opts GeneratorOptions) (root *tmplWrapper, all []*tmplWrapper, err error) {
-	tmpl := tt.New(name)
-	addDummyFuncs(opts, func(fm tt.FuncMap) { tmpl.Funcs(fm) })
-	if _, err := tmpl.Parse(text); err != nil {
-		return nil, nil, err
+// The HTML template's `Execute` method calls the `escape` private
+// method inside. It enriches a template tree with the escaping
+// commands, so we don't need to re-implement it from scratch.
+// Escaping errors (`*ht.Error`) are returned, but the execution ones
+// are ignored, cause there's no actual data.
+func escapeHTMLTemplate(tmpl *ht.Template, opts GeneratorOptions) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("html/template: escaping %q failed: %v", tmpl.Name(), r)
+		}
+	}()
+	// Add external and undefined templates to fix the escaping
+	for _, name := range placeholderTmplNames(tmpl, opts) {
+		t, err := ht.New(name).Parse("")
+		if err != nil {
+			return err
+		}
+		if _, err := tmpl.AddParseTree(name, t.Tree); err != nil {
+			return err
+		}
 	}
-	root, all = wrapTmpls(tmpl, nil, opts)
-	return root, all, nil
+	// Only the reachable templates are escaped on execution, so execute
+	// the defined ones as well.
+	if err := execHTMLTemplate(tmpl, func() error { return tmpl.Execute(io.Discard, "") }); err != nil {
+		return err
+	}
+	tmpls := tmpl.Templates()
+	slices.SortFunc(tmpls, func(a, b *ht.Template) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	for _, t := range tmpls {
+		if err := execHTMLTemplate(t, func() error { return tmpl.ExecuteTemplate(io.Discard, t.Name(), "") }); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func parseHTMLTemplate(name, text string, opts GeneratorOptions) (root *tmplWrapper, all []*tmplWrapper, err error) {
-	tmpl := ht.New(name)
-	addDummyFuncs(opts, func(fm tt.FuncMap) { tmpl.Funcs(fm) })
-	if _, err := tmpl.Parse(text); err != nil {
-		return nil, nil, err
+func execHTMLTemplate(tmpl *ht.Template, exec func() error) error {
+	if tmpl.Tree == nil {
+		return nil
 	}
-	root, all = wrapTmpls(nil, tmpl, opts)
-	// The HTML template's `Execute` method calls the `escape` private
-	// method inside. It enriches a template tree with the escaping
-	// commands, so we don't need to re-implement it from scratch.
-	// Thus we don't care about the actual execution and ignore all its
-	// possible errors and panics.
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Fprintf(os.Stderr, "[recovery] %v\n", r)
+	if err := exec(); err != nil {
+		var e *ht.Error
+		if errors.As(err, &e) {
+			return e
 		}
-	}()
-	if opts.Tmpls != nil {
-		// Add external templates to fix the execution
-		for _, info := range opts.Tmpls {
-			name := info.Name
-			if t, err := ht.New(name).Parse(""); err != nil {
-				fmt.Fprintf(os.Stderr, "[xtmpl] %v\n", err)
-			} else {
-				tmpl.AddParseTree(name, t.Tree)
-			}
+	}
+	return nil
+}
+
+// Returns the sorted names of the external templates and the ones, which
+// are called, but not defined.
+func placeholderTmplNames(tmpl *ht.Template, opts GeneratorOptions) []string {
+	defined := make(map[string]bool)
+	for _, t := range tmpl.Templates() {
+		defined[t.Name()] = true
+	}
+	names := make([]string, 0)
+	add := func(name string) {
+		if !defined[name] {
+			defined[name] = true
+			names = append(names, name)
 		}
 	}
-	_ = tmpl.Execute(io.Discard, "")
-	return root, all, nil
+	for _, info := range opts.Tmpls {
+		add(info.Name)
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		walkNodes(t.Tree.Root, func(n parse.Node) {
+			if n, ok := n.(*parse.TemplateNode); ok {
+				add(n.Name)
+			}
+		})
+	}
+	slices.Sort(names)
+	return names
 }
 
-func generateFile(rw *tmplWrapper, wrappers []*tmplWrapper, opts GeneratorOptions) *ast.File {
-	scope := scopes.NewRootScope(rw.root)
-	imports := newImports()
-	if opts.Imports != nil {
-		for _, s := range opts.Imports {
-			// e.g. "net/http", "example.com/a/b/c"
-			if i := strings.LastIndex(s, "/"); i != 1 {
-				imports.use(s[(i+1):], s, scope)
-			} else { // e.g. "math"
-				imports.use(s, s, scope)
+func generateFile(rw *tmplWrapper, wrappers []*tmplWrapper, opts GeneratorOptions, env *typeEnv, diags *diagnostics) *ast.File {
+	imports := newImports(opts.Imports, env)
+	// The template variables can't shadow the imports, the user functions
+	// and the generated ones, so their names are reserved up front. Each
+	// function declares its own variables.
+	reserved := imports.names()
+	for _, fn := range opts.Funcs {
+		// e.g. "strconv.Atoi"
+		name, _, _ := strings.Cut(fn, ".")
+		reserved = append(reserved, name)
+	}
+	for _, w := range wrappers {
+		reserved = append(reserved, w.fnName)
+	}
+	scope := scopes.NewRootScope(nil, reserved...)
+	layout := make([]*tmplWrapper, 0, len(wrappers))
+	for _, w := range wrappers {
+		if w.page == nil {
+			layout = append(layout, w)
+		}
+	}
+	// The layout's templates the pages redefine are overridden like blocks
+	blocks := blockNames(layout)
+	for _, w := range wrappers {
+		if w.page != nil {
+			for tn := range w.page.fnNames {
+				if _, ok := rw.fnNames[tn]; ok {
+					blocks[tn] = true
+				}
 			}
 		}
 	}
+	overrides := blockOverrides(layout, blocks)
 	decls := make([]ast.Decl, 0)
 	for _, w := range wrappers {
 		fn := generateFunction(
 			w,
 			scope,
 			imports,
-			opts.Mode,
+			blocks,
+			overrides,
+			opts,
+			env,
+			diags,
 		)
 		decls = append(decls, fn)
 	}
@@ -170,19 +585,102 @@ func generateFile(rw *tmplWrapper, wrappers []*tmplWrapper, opts GeneratorOption
 	}
 }
 
-func generateFunction(wrapper *tmplWrapper, rootScope *scopes.RootScope, imports *imports, mode Mode) *ast.FuncDecl {
+func generateFunction(
+	wrapper *tmplWrapper,
+	rootScope *scopes.RootScope,
+	imports *imports,
+	blocks map[string]bool,
+	overrides map[string][]string,
+	opts GeneratorOptions,
+	env *typeEnv,
+	diags *diagnostics,
+) *ast.FuncDecl {
 	scope := scopes.NewListScope(rootScope, wrapper.root)
 	g := &Generator{
-		mode:      mode,
-		outIdent:  scopes.Uniq(scope, "output"),
-		dataIdent: scope.Dot(),
-		eoutIdent: scopes.Uniq(scope, "errOutput"),
-		imports:   imports,
-		usedTmpls: make(map[string]bool),
+		mode:       opts.Mode,
+		tree:       wrapper.tree,
+		src:        wrapper.src,
+		diags:      diags,
+		outIdent:   scopes.Uniq(scope, "output"),
+		dataIdent:  scope.Dot(),
+		dataType:   wrapper.dataType,
+		eoutIdent:  scopes.Uniq(scope, "errOutput"),
+		imports:    imports,
+		usedTmpls:  make(map[string]bool),
+		tmplParams: make(map[string]*ast.Ident),
+		fnScope:    scope,
+		tmpls:      wrapper.fnNames,
+		tmplBlocks: overrides,
+		overrides:  make(map[string]ast.Expr),
+		env:        env,
+		exprTypes:  make(map[ast.Expr]types.Type),
+		varDecls:   make(map[*ast.Ident]*parse.VariableNode),
+		varAssigns: make(map[*parse.VariableNode][]ast.Expr),
+		varTypes:   make(map[*parse.VariableNode]types.Type),
+
+		sortMaps:       opts.SortMaps,
+		safe:           opts.Safe,
+		recoverMode:    opts.Recover,
+		returnErrors:   opts.ReturnErrors,
+		lineDirectives: opts.LineDirectives,
+		comments:       opts.Comments,
+	}
+	var overrideNames []string
+	if p := wrapper.page; p != nil {
+		// The page's templates are known, so the blocks are resolved
+		// statically, and the layout's functions get the page's ones.
+		g.staticBlocks = true
+		for name := range blocks {
+			if fn, ok := p.fnNames[name]; ok {
+				g.overrides[name] = ast.NewIdent(fn)
+			} else {
+				g.overrides[name] = nilIdent
+			}
+		}
+	} else {
+		overrideNames = overrides[wrapper.fnName]
+		for _, name := range overrideNames {
+			g.overrides[name] = scopes.Uniq(scope, paramName(name))
+		}
 	}
+	// The external templates' arguments are declared before the body's
+	// variables, so they aren't shadowed.
+	walkNodes(wrapper.root, func(n parse.Node) {
+		if n, ok := n.(*parse.TemplateNode); ok {
+			if name := unmangleTmplName(n.Name); g.tmpls[name] == "" {
+				g.tmplParam(name)
+			}
+		}
+	})
+	if g.recoverMode != RecoverNone {
+		g.posIdent = scopes.Uniq(scope, "pos")
+	}
+	if opts.StickyOutput {
+		g.outParam = g.outIdent
+		g.outIdent = scopes.Uniq(scope, "out")
+	}
+	g.typed(g.dataIdent, env.lookup(wrapper.dataType))
+	ndiags, used := len(diags.list), maps.Clone(imports.used)
 	body := g.listNodeStmt(wrapper.root, scope)
+	if g.widenVars() {
+		// The uses of the widened variables depend on their types, so the
+		// body is generated again from scratch
+		diags.list = diags.list[:ndiags]
+		imports.used = used
+		body = g.listNodeStmt(wrapper.root, scope)
+	}
+	dropUnusedVars(body)
+	if g.outParam != nil {
+		if usesIdent(body, g.outIdent) {
+			body.List = append([]ast.Stmt{g.stickyOutputStmt(g.outParam)}, body.List...)
+		}
+		g.outIdent = g.outParam
+	}
+	if g.posIdent != nil {
+		body.List = append(g.deferRecoverStmts(wrapper.root), body.List...)
+	}
 	iowr := &ast.SelectorExpr{
-		X:   g.useIO(scope),
+		X:   g.useIO(),
 		Sel: ast.NewIdent("Writer"),
 	}
 	declArgs := []*ast.Field{
@@ -209,20 +707,46 @@ func generateFunction(wrapper *tmplWrapper, rootScope *scopes.RootScope, imports
 	slices.SortFunc(usedTmpls, func(a, b NamedTemplateInfo) int {
 		return strings.Compare(a.Name, b.Name)
 	})
-	for _, t := range usedTmpls {
+	tmplFuncType := func(t NamedTemplateInfo) ast.Expr {
 		args := []*ast.Field{{Type: iowr}}
 		if len(t.DataType) > 0 {
 			args = append(args, &ast.Field{Type: ast.NewIdent(t.DataType)})
 		}
+		if g.returnErrors {
+			return &ast.FuncType{
+				Params:  &ast.FieldList{List: args},
+				Results: errorResults(),
+			}
+		}
 		args = append(args, &ast.Field{Type: iowr})
+		return &ast.FuncType{
+			Params: &ast.FieldList{
+				List: args,
+			},
+		}
+	}
+	for _, t := range usedTmpls {
+		declArgs = append(declArgs, &ast.Field{
+			Names: []*ast.Ident{g.tmplParam(t.Name)},
+			Type:  tmplFuncType(t),
+		})
+	}
+	// The optional overrides of the blocks go after the external templates
+	for _, name := range overrideNames {
 		declArgs = append(declArgs, &ast.Field{
-			Names: []*ast.Ident{scopes.Uniq(scope, t.Name)},
+			Names: []*ast.Ident{g.overrides[name].(*ast.Ident)},
+			Type:  tmplFuncType(wrapper.infos[name]),
+		})
+	}
+	if g.returnErrors {
+		return &ast.FuncDecl{
+			Name: ast.NewIdent(wrapper.fnName),
 			Type: &ast.FuncType{
-				Params: &ast.FieldList{
-					List: args,
-				},
+				Params:  &ast.FieldList{List: declArgs},
+				Results: errorResults(),
 			},
-		})
+			Body: g.executeBody(body, iowr),
+		}
 	}
 	declArgs = append(declArgs, &ast.Field{
 		Names: []*ast.Ident{g.eoutIdent},
@@ -239,89 +763,264 @@ func generateFunction(wrapper *tmplWrapper, rootScope *scopes.RootScope, imports
 	}
 }
 
+// The body is executed by `tmtr.Execute`, which stops at the first error
+// written to `errOutput` and returns it like `Template.Execute` does:
+//
+//	return tmtr.Execute(func(errOutput io.Writer) {
+//		...
+//	})
+func (g *Generator) executeBody(body *ast.BlockStmt, iowr ast.Expr) *ast.BlockStmt {
+	return &ast.BlockStmt{
+		List: []ast.Stmt{
+			&ast.ReturnStmt{
+				Results: []ast.Expr{
+					&ast.CallExpr{
+						Fun: &ast.SelectorExpr{
+							X:   g.useFuncs(),
+							Sel: executeIdent,
+						},
+						Args: []ast.Expr{
+							&ast.FuncLit{
+								Type: &ast.FuncType{
+									Params: &ast.FieldList{
+										List: []*ast.Field{
+											{
+												Names: []*ast.Ident{g.eoutIdent},
+												Type:  iowr,
+											},
+										},
+									},
+								},
+								Body: body,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func errorResults() *ast.FieldList {
+	return &ast.FieldList{
+		List: []*ast.Field{{Type: ast.NewIdent("error")}},
+	}
+}
+
+// Returns the function argument of the external template.
+func (g *Generator) tmplParam(name string) *ast.Ident {
+	id, ok := g.tmplParams[name]
+	if !ok {
+		id = scopes.Uniq(g.fnScope, paramName(name))
+		g.tmplParams[name] = id
+	}
+	return id
+}
+
 type tmplWrapper struct {
-	text             *tt.Template
-	html             *ht.Template
+	tree             *parse.Tree
 	root             *parse.ListNode
+	src              string
+	name             string
 	fnName, dataType string
 	infos            map[string]NamedTemplateInfo
+	// the function names of the defined templates the wrapper sees
+	fnNames map[string]string
+	// nil if it's the layout's template
+	page *pageFuncs
+}
+
+// The functions of a page's templates.
+type pageFuncs struct {
+	name    string
+	fnNames map[string]string
 }
 
 func newTmplWrapper(
-	text *tt.Template,
-	html *ht.Template,
-	fnName, dataType string,
+	tree *parse.Tree,
+	name, fnName, dataType string,
 	infos map[string]NamedTemplateInfo,
+	fnNames map[string]string,
 ) *tmplWrapper {
-	w := &tmplWrapper{
-		text:     text,
-		html:     html,
+	return &tmplWrapper{
+		tree:     tree,
+		root:     tree.Root,
+		name:     name,
 		fnName:   fnName,
 		dataType: dataType,
 		infos:    infos,
+		fnNames:  fnNames,
 	}
-	if text != nil {
-		w.root = text.Root
+}
+
+// Describes the wrapper for the error messages.
+func (w *tmplWrapper) String() string {
+	if w.page == nil {
+		return fmt.Sprintf("%q", w.name)
 	}
-	if html != nil {
-		w.root = html.Tree.Root
+	if _, ok := w.page.fnNames[w.name]; !ok && !isDerivedTmplName(w.name) {
+		return fmt.Sprintf("page %q", w.page.name)
 	}
-	return w
+	return fmt.Sprintf("%q of page %q", w.name, w.page.name)
 }
 
-func wrapTmpls(text *tt.Template, html *ht.Template, opts GeneratorOptions) (root *tmplWrapper, all []*tmplWrapper) {
+// The layout's root template is rendered by `opts.FnName`, and the others
+// get the suffixes (e.g. "RenderIndexFoo" for "foo"). So does each page and
+// its templates (e.g. "RenderIndexAbout" for "about.html" and
+// "RenderIndexAboutFoo" for its "foo").
+func wrapTmpls(
+	rootName string,
+	trees map[string]*parse.Tree,
+	pages []*page,
+	fileNames map[string]bool,
+	opts GeneratorOptions,
+) (root *tmplWrapper, all []*tmplWrapper, err error) {
 	all = make([]*tmplWrapper, 0)
 	infos := make(map[string]NamedTemplateInfo)
+	fnNames := make(map[string]string)
 	if opts.Tmpls != nil {
 		for _, v := range opts.Tmpls {
 			infos[v.Name] = v
 		}
 	}
-	if tmpl := text; tmpl != nil {
-		for _, t := range tmpl.Templates() {
-			tn := t.Name()
-			infos[tn] = NamedTemplateInfo{
-				Name:     tn,
-				DataType: opts.DataType,
-			}
-			fn := opts.FnName
-			if t != tmpl {
-				fn += upperFirstLetter(tn)
-			}
-			w := newTmplWrapper(t, nil, fn, opts.DataType, infos)
-			if t == tmpl {
-				root = w
-			}
-			all = append(all, w)
+	fnSuffix := func(tn string) string {
+		if fileNames[tn] {
+			tn = strings.TrimSuffix(tn, filepath.Ext(tn))
 		}
+		return upperFirstLetter(identName(tn))
 	}
-	if tmpl := html; tmpl != nil {
-		for _, t := range tmpl.Templates() {
-			tn := t.Name()
+	named := make(map[string]bool, len(opts.FnNames))
+	// Returns the specified function name if any
+	fnName := func(tn, fn string) string {
+		if s, ok := opts.FnNames[tn]; ok {
+			named[tn] = true
+			return s
+		}
+		return fn
+	}
+	for tn := range trees {
+		if isDerivedTmplName(tn) {
+			continue
+		}
+		infos[tn] = NamedTemplateInfo{
+			Name:     tn,
+			DataType: opts.DataType,
+		}
+		fn := opts.FnName
+		if tn != rootName {
+			fn = fnName(tn, fn+fnSuffix(tn))
+		}
+		fnNames[tn] = fn
+	}
+	// A variant for each context, e.g. "RenderIndexFooInAttr"
+	for tn := range trees {
+		if isDerivedTmplName(tn) {
+			fnNames[tn] = fnNames[unmangleTmplName(tn)] + contextSuffix(tn)
+		}
+	}
+	for tn, tree := range trees {
+		w := newTmplWrapper(tree, tn, fnNames[tn], opts.DataType, infos, fnNames)
+		if tn == rootName {
+			root = w
+		}
+		all = append(all, w)
+	}
+	for _, p := range pages {
+		prefix := fnName(p.name, opts.FnName+fnSuffix(p.name))
+		pf := &pageFuncs{
+			name:    p.name,
+			fnNames: make(map[string]string, len(p.defs)),
+		}
+		names := maps.Clone(fnNames)
+		for _, tn := range p.defs {
 			infos[tn] = NamedTemplateInfo{
 				Name:     tn,
 				DataType: opts.DataType,
 			}
-			fn := opts.FnName
-			if t != tmpl {
-				fn += upperFirstLetter(tn)
+			names[tn] = prefix + fnSuffix(tn)
+			pf.fnNames[tn] = names[tn]
+		}
+		// The variants of the page's templates, and the ones of the layout's
+		// templates, which are called in the new contexts.
+		defs := slices.Clone(p.defs)
+		for tn := range p.trees {
+			if !isDerivedTmplName(tn) {
+				continue
 			}
-			w := newTmplWrapper(nil, t, fn, opts.DataType, infos)
-			if t == tmpl {
-				root = w
+			_, own := pf.fnNames[unmangleTmplName(tn)]
+			if _, ok := trees[tn]; own || !ok {
+				names[tn] = prefix + fnSuffix(unmangleTmplName(tn)) + contextSuffix(tn)
+				defs = append(defs, tn)
 			}
+		}
+		// The page renders the layout
+		w := newTmplWrapper(p.trees[rootName], rootName, prefix, opts.DataType, infos, names)
+		w.page = pf
+		all = append(all, w)
+		for _, tn := range defs {
+			w := newTmplWrapper(p.trees[tn], tn, names[tn], opts.DataType, infos, names)
+			w.page = pf
 			all = append(all, w)
 		}
 	}
+	for tn := range opts.FnNames {
+		if !named[tn] {
+			return nil, nil, fmt.Errorf("can't name the function of %q: no such template in the layout or page", tn)
+		}
+	}
 	slices.SortFunc(all, func(a, b *tmplWrapper) int {
-		return strings.Compare(a.fnName, b.fnName)
+		if c := strings.Compare(a.fnName, b.fnName); c != 0 {
+			return c
+		}
+		return strings.Compare(a.String(), b.String())
 	})
-	return root, all
+	// The names could collide (e.g. "foo-bar" and "fooBar")
+	for i := 1; i < len(all); i++ {
+		if a, b := all[i-1], all[i]; a.fnName == b.fnName {
+			return nil, nil, fmt.Errorf("%v and %v conflict: both are rendered by %s", a, b, b.fnName)
+		}
+	}
+	return root, all, nil
 }
 
 func upperFirstLetter(s string) string {
-	if len(s) > 1 {
-		return strings.ToUpper(s[:1]) + s[1:]
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+// Turns a template name into a part of an identifier deterministically: the
+// letters and digits are kept, and the rest separate the capitalized words
+// (e.g. "nav-bar" -> "navBar", "header.html" -> "headerHtml"). It's "_" if
+// nothing is left.
+func identName(s string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			upper = b.Len() > 0
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// Returns the identifier for the function argument of a template (e.g.
+// "_1col" for "1col").
+func paramName(tn string) string {
+	s := identName(tn)
+	if r, _ := utf8.DecodeRuneInString(s); unicode.IsDigit(r) {
+		return "_" + s
 	}
-	return strings.ToUpper(s)
+	return s
 }
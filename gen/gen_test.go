@@ -1,10 +1,20 @@
 package gen
 
 import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
 	"go/printer"
 	"go/token"
+	"go/types"
+	ht "html/template"
+	"io"
+	"reflect"
 	"strings"
 	"testing"
+	tt "text/template"
 
 	"github.com/apleshkov/tmtr/util"
 )
@@ -111,14 +121,63 @@ func TestFunctions(t *testing.T) {
 		t, ModeHTML,
 		`{{and 0 1 2}}`,
 		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
-            tmtr.Write(output, tmtr.EscapeHTML(tmtr.And(0, 1, 2)), errOutput)
+            tmtr.Write(output, tmtr.EscapeHTML(func() int {
+                if v := 0; tmtr.IsNotTrue(v) {
+                    return v
+                }
+                if v := 1; tmtr.IsNotTrue(v) {
+                    return v
+                }
+                return 2
+            }()), errOutput)
         }`,
 	)
 	testFuncOutput(
 		t, ModeHTML,
 		`{{or 0 1 2}}`,
 		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
-            tmtr.Write(output, tmtr.EscapeHTML(tmtr.Or(0, 1, 2)), errOutput)
+            tmtr.Write(output, tmtr.EscapeHTML(func() int {
+                if v := 0; tmtr.IsTrue(v) {
+                    return v
+                }
+                if v := 1; tmtr.IsTrue(v) {
+                    return v
+                }
+                return 2
+            }()), errOutput)
+        }`,
+	)
+	testFuncOutput(
+		t, ModeText,
+		`{{if and .User .User.IsAdmin}}admin{{end}}`,
+		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
+            if tmtr.IsTrue(func() any {
+                if v := data.User; tmtr.IsNotTrue(v) {
+                    return v
+                }
+                return data.User.IsAdmin
+            }()) {
+                tmtr.Write(output, "admin", errOutput)
+            }
+        }`,
+	)
+	testFuncOutput(
+		t, ModeText,
+		`{{.Name | or .Nick}}`,
+		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, func() any {
+                if v := data.Nick; tmtr.IsTrue(v) {
+                    return v
+                }
+                return data.Name
+            }(), errOutput)
+        }`,
+	)
+	testFuncOutput(
+		t, ModeText,
+		`{{or true false (and false true)}}`,
+		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, true || false || (false && true), errOutput)
         }`,
 	)
 	testFuncOutput(
@@ -282,49 +341,71 @@ func TestComparisonOps(t *testing.T) {
 		t, ModeHTML,
 		`{{eq . 1}}`,
 		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
-            tmtr.Write(output, tmtr.EscapeHTML(data == 1), errOutput)
+            tmtr.Write(output, tmtr.EscapeHTML(tmtr.Eq(errOutput, data, 1)), errOutput)
         }`,
 	)
 	testFuncOutput(
 		t, ModeHTML,
 		`{{eq . 1 2 3}}`,
 		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
-            tmtr.Write(output, tmtr.EscapeHTML(data == 1 || data == 2 || data == 3), errOutput)
+            tmtr.Write(output, tmtr.EscapeHTML(tmtr.Eq(errOutput, data, 1, 2, 3)), errOutput)
         }`,
 	)
 	testFuncOutput(
 		t, ModeHTML,
 		`{{ne . 1}}`,
 		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
-            tmtr.Write(output, tmtr.EscapeHTML(data != 1), errOutput)
+            tmtr.Write(output, tmtr.EscapeHTML(tmtr.Ne(errOutput, data, 1)), errOutput)
         }`,
 	)
 	testFuncOutput(
 		t, ModeHTML,
 		`{{lt . 1}}`,
 		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
-            tmtr.Write(output, tmtr.EscapeHTML(data < 1), errOutput)
+            tmtr.Write(output, tmtr.EscapeHTML(tmtr.Lt(errOutput, data, 1)), errOutput)
         }`,
 	)
 	testFuncOutput(
 		t, ModeHTML,
 		`{{le . 1}}`,
 		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
-            tmtr.Write(output, tmtr.EscapeHTML(data <= 1), errOutput)
+            tmtr.Write(output, tmtr.EscapeHTML(tmtr.Le(errOutput, data, 1)), errOutput)
         }`,
 	)
 	testFuncOutput(
 		t, ModeHTML,
 		`{{gt . 1}}`,
 		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
-            tmtr.Write(output, tmtr.EscapeHTML(data > 1), errOutput)
+            tmtr.Write(output, tmtr.EscapeHTML(tmtr.Gt(errOutput, data, 1)), errOutput)
         }`,
 	)
 	testFuncOutput(
 		t, ModeHTML,
 		`{{ge . 1}}`,
 		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
-            tmtr.Write(output, tmtr.EscapeHTML(data >= 1), errOutput)
+            tmtr.Write(output, tmtr.EscapeHTML(tmtr.Ge(errOutput, data, 1)), errOutput)
+        }`,
+	)
+}
+
+func TestTypedComparisonOps(t *testing.T) {
+	testTypedFuncOutput(
+		t, ModeText, testTypedSrc,
+		`{{eq .ID 1 2}} {{lt .Title "b"}} {{eq .Ptr .Ptr}} {{eq .Extra 1}} {{lt .ID 1.5}} {{ge .ID .Title}} {{ne .Items nil}}`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            tmtr.WriteBool(output, data.ID == 1 || data.ID == 2, errOutput)
+            tmtr.WriteString(output, " ", errOutput)
+            tmtr.WriteBool(output, data.Title < "b", errOutput)
+            tmtr.WriteString(output, " ", errOutput)
+            tmtr.WriteBool(output, data.Ptr == data.Ptr, errOutput)
+            tmtr.WriteString(output, " ", errOutput)
+            tmtr.WriteBool(output, tmtr.Eq(errOutput, data.Extra, 1), errOutput)
+            tmtr.WriteString(output, " ", errOutput)
+            tmtr.WriteBool(output, tmtr.Lt(errOutput, data.ID, 1.5), errOutput)
+            tmtr.WriteString(output, " ", errOutput)
+            tmtr.WriteBool(output, tmtr.Ge(errOutput, data.ID, data.Title), errOutput)
+            tmtr.WriteString(output, " ", errOutput)
+            tmtr.WriteBool(output, tmtr.Ne(errOutput, data.Items, nil), errOutput)
         }`,
 	)
 }
@@ -348,6 +429,119 @@ func TestVars(t *testing.T) {
 	)
 }
 
+func TestVarNames(t *testing.T) {
+	testFuncOutput(
+		t, ModeText,
+		"{{$type := .}}{{$tmtr := 1}}{{$output := 2}}{{$errOutput := 3}}{{$type}}{{$tmtr}}{{$output}}{{$errOutput}}",
+		`func RenderTest(output_ io.Writer, data any, errOutput_ io.Writer) {
+            type_ := data
+            tmtr_ := 1
+            output := 2
+            errOutput := 3
+            tmtr.Write(output_, type_, errOutput_)
+            tmtr.Write(output_, tmtr_, errOutput_)
+            tmtr.Write(output_, output, errOutput_)
+            tmtr.Write(output_, errOutput, errOutput_)
+        }`,
+	)
+	testFuncOutput(
+		t, ModeText,
+		`{{range $len := .}}{{$io := $len}}{{$foo := $io}}{{template "foo" $foo}}{{end}}`,
+		`func RenderTest(output io.Writer, data any, foo func(io.Writer, any, io.Writer), errOutput io.Writer) {
+            if list := data; tmtr.IsTrue(list) {
+                for _, len_ := range list {
+                    io_ := len_
+                    foo_ := io_
+                    foo(output, foo_, errOutput)
+                }
+            }
+        }`,
+	)
+}
+
+func TestUnusedVars(t *testing.T) {
+	testFuncOutput(
+		t, ModeText,
+		"{{range $i, $v := .}}{{$i}}{{end}}{{range $i, $v := .}}{{end}}{{range .}}x{{end}}",
+		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
+            if list := data; tmtr.IsTrue(list) {
+                for i := range list {
+                    tmtr.Write(output, i, errOutput)
+                }
+            }
+            if list := data; tmtr.IsTrue(list) {
+                for range list {
+                }
+            }
+            if list := data; tmtr.IsTrue(list) {
+                for range list {
+                    tmtr.Write(output, "x", errOutput)
+                }
+            }
+        }`,
+	)
+	testTypedFuncOutput(
+		t, ModeText, testTypedSrc,
+		"{{range $i, $v := .Items}}{{$i}}{{end}}{{range $i, $v := .Items}}{{$v}}{{end}}{{range .Items}}x{{end}}{{$x := 1}}{{if .ID}}{{$x = 2}}{{end}}",
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            if list := data.Items; tmtr.IsTrue(list) {
+                for i := range list {
+                    tmtr.WriteInt(output, int64(i), errOutput)
+                }
+            }
+            if list := data.Items; tmtr.IsTrue(list) {
+                for _, v := range list {
+                    tmtr.Write(output, v, errOutput)
+                }
+            }
+            if list := data.Items; tmtr.IsTrue(list) {
+                for range list {
+                    tmtr.WriteString(output, "x", errOutput)
+                }
+            }
+            x := 1
+            _ = x
+            if tmtr.IsTrue(data.ID) {
+                x = 2
+            }
+        }`,
+	)
+}
+
+func TestReassignedVars(t *testing.T) {
+	testFuncOutput(
+		t, ModeText,
+		`{{$x := 0}}{{if .Flag}}{{$x = "a"}}{{end}}{{$x}}{{$y := .A}}{{$y = .B}}{{$y}}`,
+		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
+            x := any(0)
+            if tmtr.IsTrue(data.Flag) {
+                x = "a"
+            }
+            tmtr.Write(output, x, errOutput)
+            y := data.A
+            y = data.B
+            tmtr.Write(output, y, errOutput)
+        }`,
+	)
+	testTypedFuncOutput(
+		t, ModeText, testTypedSrc,
+		`{{$x := 0}}{{if .Title}}{{$x = .Title}}{{end}}{{$x}}{{$f := 1}}{{$f = 1.5}}{{$f}}{{$p := .Ptr}}{{$p = .Item}}{{$p.Title}}`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            x := any(0)
+            if tmtr.IsTrue(data.Title) {
+                x = data.Title
+            }
+            tmtr.Write(output, x, errOutput)
+            f := float64(1)
+            f = 1.5
+            tmtr.Write(output, f, errOutput)
+            p := data.Ptr
+            p = data.Item()
+            tmtr.WriteString(output, p.Title, errOutput)
+        }`,
+	)
+}
+
 func TestIfStmt(t *testing.T) {
 	testFuncOutput(
 		t, ModeText,
@@ -362,7 +556,7 @@ func TestIfStmt(t *testing.T) {
 		t, ModeText,
 		"{{if eq . 1}}{{.}}{{end}}",
 		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
-            if tmtr.IsTrue(data == 1) {
+            if tmtr.IsTrue(tmtr.Eq(errOutput, data, 1)) {
                 tmtr.Write(output, data, errOutput)
             }
         }`,
@@ -382,10 +576,10 @@ func TestIfStmt(t *testing.T) {
 		t, ModeText,
 		"{{if eq . 1}}1{{else if eq . 2}}2{{else}}{{.}}{{end}}",
 		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
-            if tmtr.IsTrue(data == 1) {
+            if tmtr.IsTrue(tmtr.Eq(errOutput, data, 1)) {
                 tmtr.Write(output, "1", errOutput)
             } else {
-                if tmtr.IsTrue(data == 2) {
+                if tmtr.IsTrue(tmtr.Eq(errOutput, data, 2)) {
                     tmtr.Write(output, "2", errOutput)
                 } else {
                     tmtr.Write(output, data, errOutput)
@@ -405,6 +599,17 @@ func TestIfStmt(t *testing.T) {
 }
 
 func TestRangeStmt(t *testing.T) {
+	testFuncOutput(
+		t, ModeText,
+		"{{range $i := 5}}{{$i}}{{end}}",
+		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
+            if list := 5; list > 0 {
+                for i := range list {
+                    tmtr.Write(output, i, errOutput)
+                }
+            }
+        }`,
+	)
 	testFuncOutput(
 		t, ModeText,
 		"{{range .}}{{.}}{{end}}",
@@ -464,7 +669,9 @@ func TestRangeStmt(t *testing.T) {
                 }
             } else {
                 list := 0
+                _ = list
                 elem := 1
+                _ = elem
             }
         }`,
 	)
@@ -576,7 +783,7 @@ func TestParentData(t *testing.T) {
             if list := data; tmtr.IsTrue(list) {
                 for _, elem := range list {
                     tmtr.Write(output, elem, errOutput)
-                    tmtr.Write(output, list, errOutput)
+                    tmtr.Write(output, data, errOutput)
                 }
             } else {
                 tmtr.Write(output, data, errOutput)
@@ -620,24 +827,24 @@ func TestParentData(t *testing.T) {
                     if with := v; tmtr.IsTrue(with) {
                         tmtr.Write(output, "\n\t\t\t\t", errOutput)
                         tmtr.Write(output, with, errOutput)
-                        tmtr.Write(output, v, errOutput)
+                        tmtr.Write(output, data, errOutput)
                         tmtr.Write(output, "\n\t\t\t\t", errOutput)
                         if tmtr.IsTrue(with) {
                             tmtr.Write(output, "\n\t\t\t\t\t", errOutput)
                             tmtr.Write(output, with, errOutput)
-                            tmtr.Write(output, v, errOutput)
+                            tmtr.Write(output, data, errOutput)
                             tmtr.Write(output, "\n\t\t\t\t", errOutput)
                         } else {
                             tmtr.Write(output, "\n\t\t\t\t\t", errOutput)
                             tmtr.Write(output, with, errOutput)
-                            tmtr.Write(output, v, errOutput)
+                            tmtr.Write(output, data, errOutput)
                             tmtr.Write(output, "\n\t\t\t\t", errOutput)
                         }
                         tmtr.Write(output, "\n\t\t\t", errOutput)
                     } else {
                         tmtr.Write(output, "\n\t\t\t\t", errOutput)
                         tmtr.Write(output, v, errOutput)
-                        tmtr.Write(output, list, errOutput)
+                        tmtr.Write(output, data, errOutput)
                         tmtr.Write(output, "\n\t\t\t", errOutput)
                     }
                     tmtr.Write(output, "\n\t\t", errOutput)
@@ -945,6 +1152,718 @@ func TestNormalizeURL(t *testing.T) {
 	)
 }
 
+func TestEscapingErrors(t *testing.T) {
+	data := []struct {
+		tmpl string
+		code ht.ErrorCode
+		loc  string
+	}{
+		{`<a href="{{if .}}/search?q={{else}}/{{end}}{{.}}">`, ht.ErrAmbigContext, "test:1:45"},
+		{"\n<a {{.}}=\"x\"\">", ht.ErrBadHTML, "test"},
+		{`<script>var p = /foo[{{.}}]/</script>`, ht.ErrPartialCharset, "test"},
+		{"\n\n{{. | html | print}}", ht.ErrPredefinedEscaper, "test:3:2"},
+		{`<a {{if .}}href{{end}}="x">`, ht.ErrBranchEnd, "test:1:8"},
+		{"{{define \"foo\"}}\n<a {{if .}}href{{end}}=\"x\">{{end}}", ht.ErrBranchEnd, "test:2:8"},
+	}
+	for _, cs := range data {
+		_, _, err := generateFromText("test", cs.tmpl, newTestGeneratorOpts(ModeHTML, nil, nil, nil), nil)
+		var e *ht.Error
+		if !errors.As(err, &e) {
+			t.Errorf("%q: expected *template.Error, got %v", cs.tmpl, err)
+			continue
+		}
+		util.TestEq(t, e.ErrorCode, cs.code)
+		util.TestAssert(t, strings.HasPrefix(e.Error(), "html/template:"+cs.loc+": "))
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	_, diags, err := generateFromText(
+		"test",
+		"{{index}}\n  {{lt 1}}{{slice . 1 2 3 4}}",
+		newTestGeneratorOpts(ModeText, nil, nil, nil),
+		nil,
+	)
+	var ds Diagnostics
+	util.TestAssert(t, errors.As(err, &ds))
+	util.TestEq(t, len(diags), 3)
+	util.TestEq(t, diags.Error(), strings.Join([]string{
+		"test:1:3: error: wrong number of args for index: want at least 1 got 0",
+		"test:2:5: error: wrong number of args for lt: want 2 got 1",
+		"test:2:13: error: too many slice indexes: 4",
+	}, "\n"))
+	var buf strings.Builder
+	util.TestAssert(t, diags[:1].Fprint(&buf, DiagJSON) == nil)
+	util.TestEq(t, buf.String(), `{"file":"test","line":1,"col":3,"severity":"error","message":"wrong number of args for index: want at least 1 got 0"}`+"\n")
+	// parsing
+	_, diags, _ = generateFromText("test", "\n{{if}}", newTestGeneratorOpts(ModeText, nil, nil, nil), nil)
+	util.TestEq(t, diags.Error(), "test:2: error: missing value for if")
+	// escaping
+	_, diags, _ = generateFromText("test", "<a {{if .}}href{{end}}=\"x\">", newTestGeneratorOpts(ModeHTML, nil, nil, nil), nil)
+	util.TestEq(t, len(diags), 1)
+	util.TestEq(t, diags[0].Line, 1)
+	util.TestEq(t, diags[0].Col, 9)
+}
+
+func TestLineDirectives(t *testing.T) {
+	opts := newTestGeneratorOpts(ModeText, nil, nil, nil)
+	opts.LineDirectives = true
+	opts.Comments = true
+	testOutputWithOpts(
+		t, opts,
+		"Hello, {{.Name}}!\n{{if .Items -}}\n  {{range .Items}}{{.}}{{end}}\n{{- end}}{{/* x */}}{{define \"foo\"}}\n\t{{template \"bar\"}}{{end}}",
+		`package main
+
+		import (
+			io "io"
+			tmtr "github.com/apleshkov/tmtr/funcs"
+		)
+
+		func RenderTest(output io.Writer, data any, errOutput io.Writer) {
+//line test:1
+			tmtr.Write(output, "Hello, ", errOutput)
+			// {{.Name}}
+//line test:1:6
+			tmtr.Write(output, data.Name, errOutput)
+//line test:1:13
+			tmtr.Write(output, "!\n", errOutput)
+			// {{if .Items -}}
+//line test:2:2
+			if tmtr.IsTrue(data.Items) {
+				// {{range .Items}}
+//line test:3:3
+				if list := data.Items; tmtr.IsTrue(list) {
+					for _, elem := range list {
+						// {{.}}
+//line test:3:5
+						tmtr.Write(output, elem, errOutput)
+					}
+				}
+			}
+		}
+//line test.go:31
+		func RenderTestFoo(output io.Writer, data any, bar func(io.Writer, any, io.Writer), errOutput io.Writer) {
+//line test:4:33
+			tmtr.Write(output, "\n\t", errOutput)
+			// {{template "bar"}}
+//line test:5:9
+			bar(output, errOutput)
+		}`,
+		false,
+		1,
+	)
+}
+
+func TestStripLineDirectives(t *testing.T) {
+	src := "func F() {\n//line test:1:3\n\tfoo()\n\t// {{foo}}\n}\n//line test.go:6\nfunc G() {}\n"
+	util.TestEq(t, string(StripLineDirectives([]byte(src))), "func F() {\n\tfoo()\n\t// {{foo}}\n}\nfunc G() {}\n")
+}
+
+const testTypedSrc = `package main
+
+type Named interface{ GetName() string }
+
+type Base struct{ ID int }
+
+func (b *Base) Key() string { return "" }
+
+type Item struct{ Title string }
+
+func (i Item) Upper() string { return "" }
+
+type data struct {
+	Base
+	Title string
+	Items []Item
+	Ptr   *Item
+	N     Named
+	Fn    func() string
+	Meta  map[string]string
+	Props *map[string]Item
+	Rows  []map[string]int
+	Count map[int]int
+	Extra any
+}
+
+func (d data) GetTitle() string               { return "" }
+func (d data) Prefixed(s string) string       { return "" }
+func (d data) Load() (string, error)          { return "", nil }
+func (d data) Item() *Item                    { return nil }
+func (d data) Find(s string) (*Item, error)   { return nil, nil }
+`
+
+func TestTypedRangeKinds(t *testing.T) {
+	src := `package main
+
+type data struct {
+	N    int
+	Ch   chan string
+	Seq  func(yield func(string) bool)
+	Seq2 func(yield func(int, string) bool)
+}
+`
+	testTypedFuncOutput(
+		t, ModeText, src,
+		`{{range .N}}{{.}}{{end}}{{range $i := .N}}{{if $i}}{{break}}{{end}}{{else}}none{{end}}`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            if list := data.N; list > 0 {
+                for elem := range list {
+                    tmtr.WriteInt(output, int64(elem), errOutput)
+                }
+            }
+            if list := data.N; list > 0 {
+                for i := range list {
+                    if tmtr.IsTrue(i) {
+                        break
+                    }
+                }
+            } else {
+                tmtr.WriteString(output, "none", errOutput)
+            }
+        }`,
+	)
+	testTypedFuncOutput(
+		t, ModeText, src,
+		`{{range .Ch}}{{.}}{{end}}{{range $i, $v := .Ch}}{{$i}}{{$v}}{{end}}{{range .Ch}}{{continue}}{{else}}none{{end}}`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            if list := data.Ch; list != nil {
+                for elem := range list {
+                    tmtr.WriteString(output, elem, errOutput)
+                }
+            }
+            {
+                list, i := data.Ch, -1
+                if list != nil {
+                    for v := range list {
+                        i++
+                        tmtr.WriteInt(output, int64(i), errOutput)
+                        tmtr.WriteString(output, v, errOutput)
+                    }
+                }
+            }
+            {
+                list, i := data.Ch, -1
+                if list != nil {
+                    for range list {
+                        i++
+                        continue
+                    }
+                }
+                if i < 0 {
+                    tmtr.WriteString(output, "none", errOutput)
+                }
+            }
+        }`,
+	)
+	testTypedFuncOutput(
+		t, ModeText, src,
+		`{{range .Seq}}{{.}}{{end}}{{range $k, $v := .Seq2}}{{$k}}{{$v}}{{else}}none{{end}}{{range .Seq2}}{{.}}{{end}}`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            if list := data.Seq; list != nil {
+                for elem := range list {
+                    tmtr.WriteString(output, elem, errOutput)
+                }
+            }
+            {
+                list, i := data.Seq2, -1
+                if list != nil {
+                    for k, v := range list {
+                        i++
+                        tmtr.WriteInt(output, int64(k), errOutput)
+                        tmtr.WriteString(output, v, errOutput)
+                    }
+                }
+                if i < 0 {
+                    tmtr.WriteString(output, "none", errOutput)
+                }
+            }
+            if list := data.Seq2; list != nil {
+                for elem := range list {
+                    tmtr.WriteInt(output, int64(elem), errOutput)
+                }
+            }
+        }`,
+	)
+	opts := newTestGeneratorOpts(ModeText, nil, nil, nil)
+	opts.DataType = "data"
+	_, _, err := generateFromText("test", `{{range $i, $v := .N}}{{end}}`, opts, newTestTypeEnv(t, src, opts))
+	util.TestEq(t, err.Error(), "test:1:9: error: can't use int to iterate over more than one variable")
+}
+
+func TestSafe(t *testing.T) {
+	opts := newTestGeneratorOpts(ModeText, nil, nil, nil)
+	opts.DataType = "data"
+	opts.Safe = true
+	testOutputWithEnv(
+		t, opts, newTestTypeEnv(t, testTypedSrc, opts),
+		`{{.Ptr.Title}}{{.N.GetName}}{{index .Items 1}}{{slice .Title 1 2}}{{$p := .Ptr.Title}}{{if and .Ptr .Ptr.Title}}{{$p}}{{end}}{{.Item.Title}}{{.Ptr.Upper}}`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            if tmtr.NotNil(errOutput, "test:1:7", data.Ptr == nil, "*Item.Title") {
+                tmtr.WriteString(output, data.Ptr.Title, errOutput)
+            }
+            if tmtr.NotNil(errOutput, "test:1:19", data.N == nil, "Named.GetName") {
+                tmtr.WriteString(output, data.N.GetName(), errOutput)
+            }
+            if tmtr.InRange(errOutput, "test:1:31", 1, len(data.Items)) {
+                tmtr.Write(output, data.Items[1], errOutput)
+            }
+            if tmtr.SliceInRange(errOutput, "test:1:49", len(data.Title), 1, 2) {
+                tmtr.WriteString(output, data.Title[1:2], errOutput)
+            }
+            var p string
+            if tmtr.NotNil(errOutput, "test:1:79", data.Ptr == nil, "*Item.Title") {
+                p = data.Ptr.Title
+            }
+            if tmtr.IsTrue(func() any {
+                if v := data.Ptr; tmtr.IsNotTrue(v) {
+                    return v
+                }
+                if !tmtr.NotNil(errOutput, "test:1:105", data.Ptr == nil, "*Item.Title") {
+                    return nil
+                }
+                return data.Ptr.Title
+            }()) {
+                tmtr.WriteString(output, p, errOutput)
+            }
+            tmtr.WriteString(output, data.Item().Title, errOutput)
+            if tmtr.NotNil(errOutput, "test:1:147", data.Ptr == nil, "*Item.Upper") {
+                tmtr.WriteString(output, data.Ptr.Upper(), errOutput)
+            }
+        }`,
+		true, 1,
+	)
+}
+
+func TestRecover(t *testing.T) {
+	opts := newTestGeneratorOpts(ModeText, nil, nil, nil)
+	opts.Recover = RecoverFunc
+	testOutputWithOpts(
+		t, opts,
+		`a{{.}}{{if .}}{{.}}{{end}}`,
+		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
+            pos := "test:1:1"
+            defer tmtr.Recover(errOutput, &pos)
+            tmtr.Write(output, "a", errOutput)
+            pos = "test:1:4"
+            tmtr.Write(output, data, errOutput)
+            pos = "test:1:12"
+            if tmtr.IsTrue(data) {
+                pos = "test:1:17"
+                tmtr.Write(output, data, errOutput)
+            }
+        }`,
+		true, 1,
+	)
+	opts.Recover = RecoverAction
+	testOutputWithOpts(
+		t, opts,
+		`{{$x := .}}{{$x = 1}}{{.}}{{range .}}{{break}}{{end}}`,
+		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
+            pos := "test:1:1"
+            defer tmtr.Recover(errOutput, &pos)
+            pos = "test:1:3"
+            x := any(data)
+            _ = x
+            pos = "test:1:14"
+            func() {
+                defer tmtr.Recover(errOutput, &pos)
+                x = 1
+            }()
+            pos = "test:1:24"
+            func() {
+                defer tmtr.Recover(errOutput, &pos)
+                tmtr.Write(output, data, errOutput)
+            }()
+            pos = "test:1:35"
+            if list := data; tmtr.IsTrue(list) {
+                for range list {
+                    break
+                }
+            }
+        }`,
+		true, 1,
+	)
+}
+
+func TestReturnErrors(t *testing.T) {
+	opts := newTestGeneratorOpts(ModeText, []NamedTemplateInfo{{Name: "foo", DataType: "string"}}, nil, nil)
+	opts.ReturnErrors = true
+	opts.Recover = RecoverFunc
+	testOutputWithOpts(
+		t, opts,
+		`{{define "bar"}}{{.}}{{end}}{{template "foo" .}}{{template "bar" .}}`,
+		`func RenderTest(output io.Writer, data any, foo func(io.Writer, string) error) error {
+            return tmtr.Execute(func(errOutput io.Writer) {
+                pos := "test:1:1"
+                defer tmtr.Recover(errOutput, &pos)
+                pos = "test:1:40"
+                tmtr.Check(errOutput, foo(output, data))
+                pos = "test:1:60"
+                tmtr.Check(errOutput, RenderTestBar(output, data))
+            })
+        }
+        func RenderTestBar(output io.Writer, data any) error {
+            return tmtr.Execute(func(errOutput io.Writer) {
+                pos := "test:1:17"
+                defer tmtr.Recover(errOutput, &pos)
+                pos = "test:1:19"
+                tmtr.Write(output, data, errOutput)
+            })
+        }`,
+		true, 1,
+	)
+	opts = newTestGeneratorOpts(ModeText, nil, nil, nil)
+	opts.ReturnErrors = true
+	testFilesOutput(
+		t, opts, nil,
+		[]inputFile{
+			{name: "base.txt", text: `{{block "content" .}}{{block "nav" .}}N{{end}}{{end}}`},
+			{name: "home.txt", text: `{{define "nav"}}n{{end}}`},
+		},
+		`func RenderTest(output io.Writer, data any, content func(io.Writer, any) error, nav func(io.Writer, any) error) error {
+            return tmtr.Execute(func(errOutput io.Writer) {
+                if content != nil {
+                    tmtr.Check(errOutput, content(output, data))
+                } else {
+                    tmtr.Check(errOutput, RenderTestContent(output, data, nav))
+                }
+            })
+        }
+        func RenderTestContent(output io.Writer, data any, nav func(io.Writer, any) error) error {
+            return tmtr.Execute(func(errOutput io.Writer) {
+                if nav != nil {
+                    tmtr.Check(errOutput, nav(output, data))
+                } else {
+                    tmtr.Check(errOutput, RenderTestNav(output, data))
+                }
+            })
+        }
+        func RenderTestHome(output io.Writer, data any) error {
+            return tmtr.Execute(func(errOutput io.Writer) {
+                tmtr.Check(errOutput, RenderTestContent(output, data, RenderTestHomeNav))
+            })
+        }
+        func RenderTestHomeNav(output io.Writer, data any) error {
+            return tmtr.Execute(func(errOutput io.Writer) {
+                tmtr.Write(output, "n", errOutput)
+            })
+        }
+        func RenderTestNav(output io.Writer, data any) error {
+            return tmtr.Execute(func(errOutput io.Writer) {
+                tmtr.Write(output, "N", errOutput)
+            })
+        }`,
+		true, 0,
+	)
+}
+
+func TestStickyOutput(t *testing.T) {
+	opts := newTestGeneratorOpts(ModeText, nil, nil, nil)
+	opts.StickyOutput = true
+	testOutputWithOpts(
+		t, opts,
+		`{{define "empty"}}{{/* c */}}{{end}}{{$out := 1}}{{range .}}{{.}}{{template "empty"}}{{end}}{{$out}}`,
+		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
+            out_ := tmtr.NewOutput(output)
+            out := 1
+            if list := data; tmtr.IsTrue(list) {
+                for _, elem := range list {
+                    if out_.Failed() {
+                        break
+                    }
+                    tmtr.Write(out_, elem, errOutput)
+                    RenderTestEmpty(out_, nil, errOutput)
+                }
+            }
+            tmtr.Write(out_, out, errOutput)
+        }
+        func RenderTestEmpty(output io.Writer, data any, errOutput io.Writer) {
+        }`,
+		true, 1,
+	)
+	opts.DataType = "data"
+	testOutputWithEnv(
+		t, opts, newTestTypeEnv(t, testTypedSrc, opts),
+		`{{range .Extra}}{{.}}{{end}}{{range .Count}}{{.}}{{end}}`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            out := tmtr.NewOutput(output)
+            if list := data.Extra; tmtr.IsTrue(list) {
+                tmtr.Range(errOutput, list, 0, func(_, elem any) bool {
+                    if out.Failed() {
+                        return false
+                    }
+                    tmtr.Write(out, elem, errOutput)
+                    return true
+                })
+            }
+            if list := data.Count; tmtr.IsTrue(list) {
+                for _, elem := range list {
+                    if out.Failed() {
+                        break
+                    }
+                    tmtr.WriteInt(out, int64(elem), errOutput)
+                }
+            }
+        }`,
+		true, 1,
+	)
+}
+
+func TestSortedMaps(t *testing.T) {
+	opts := newTestGeneratorOpts(ModeText, nil, nil, nil)
+	opts.DataType = "data"
+	opts.SortMaps = true
+	testOutputWithEnv(
+		t, opts, newTestTypeEnv(t, testTypedSrc, opts),
+		`{{range $k, $v := .Meta}}{{$k}}{{$v}}{{end}}{{range .Count}}{{.}}{{else}}none{{end}}{{range $i, $e := .Count}}{{$i}}{{end}}`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            if list := data.Meta; tmtr.IsTrue(list) {
+                for _, k := range tmtr.SortedKeys(list) {
+                    v := list[k]
+                    tmtr.WriteString(output, k, errOutput)
+                    tmtr.WriteString(output, v, errOutput)
+                }
+            }
+            if list := data.Count; tmtr.IsTrue(list) {
+                for _, key := range tmtr.SortedKeys(list) {
+                    elem := list[key]
+                    tmtr.WriteInt(output, int64(elem), errOutput)
+                }
+            } else {
+                tmtr.WriteString(output, "none", errOutput)
+            }
+            if list := data.Count; tmtr.IsTrue(list) {
+                for _, i := range tmtr.SortedKeys(list) {
+                    e := list[i]
+                    _ = e
+                    tmtr.WriteInt(output, int64(i), errOutput)
+                }
+            }
+        }`,
+		true, 1,
+	)
+	testOutputWithEnv(
+		t, opts, newTestTypeEnv(t, "package main\ntype data struct{ Flags map[bool]int }\n", opts),
+		`{{range .Flags}}{{.}}{{end}}`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            if list := data.Flags; tmtr.IsTrue(list) {
+                for _, key := range tmtr.SortedBoolKeys(list) {
+                    elem := list[key]
+                    tmtr.WriteInt(output, int64(elem), errOutput)
+                }
+            }
+        }`,
+		true, 1,
+	)
+	src := "package main\ntype data struct{ Points map[[2]int]int }\n"
+	_, diags, err := generateFromText("test", `{{range .Points}}{{.}}{{end}}`, opts, newTestTypeEnv(t, src, opts))
+	util.TestEq(t, err, nil)
+	util.TestEq(t, diags.Error(), "test:1:9: warning: the keys of map[[2]int]int can't be sorted, so it's ranged over in random order")
+	// untyped
+	_, diags, err = generateFromText("test", `{{range .Meta}}{{.}}{{end}}`, opts, nil)
+	util.TestEq(t, err, nil)
+	util.TestEq(t, diags.Error(), "test:1:9: warning: the range type is unknown, so a map is ranged over in random order")
+}
+
+func TestTypedFields(t *testing.T) {
+	testTypedFuncOutput(
+		t, ModeText, testTypedSrc,
+		`{{.Title}}{{.GetTitle}}{{.Prefixed "x"}}{{"y" | .Prefixed}}{{.ID}}{{.Key}}`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            tmtr.WriteString(output, data.Title, errOutput)
+            tmtr.WriteString(output, data.GetTitle(), errOutput)
+            tmtr.WriteString(output, data.Prefixed("x"), errOutput)
+            tmtr.WriteString(output, data.Prefixed("y"), errOutput)
+            tmtr.WriteInt(output, int64(data.ID), errOutput)
+            tmtr.WriteString(output, data.Key(), errOutput)
+        }`,
+	)
+	// pointers & interfaces
+	testTypedFuncOutput(
+		t, ModeText, testTypedSrc,
+		`{{.Ptr.Upper}}{{.Item.Title}}{{.Item.Upper}}{{.N.GetName}}{{(.Find "x").Title}}`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            tmtr.WriteString(output, data.Ptr.Upper(), errOutput)
+            tmtr.WriteString(output, data.Item().Title, errOutput)
+            tmtr.WriteString(output, data.Item().Upper(), errOutput)
+            tmtr.WriteString(output, data.N.GetName(), errOutput)
+            tmtr.WriteString(output, tmtr.MayBe(errOutput, tmtr.Result(data.Find("x"))).Title, errOutput)
+        }`,
+	)
+	// scopes
+	testTypedFuncOutput(
+		t, ModeText, testTypedSrc,
+		`{{range .Items}}{{.Upper}}{{end}}{{range $i, $v := .Items}}{{$v.Upper}}{{end}}{{with .Ptr}}{{.Upper}}{{end}}{{$x := .Item}}{{$x.Upper}}{{if $y := .Item}}{{$y.Upper}}{{end}}`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            if list := data.Items; tmtr.IsTrue(list) {
+                for _, elem := range list {
+                    tmtr.WriteString(output, elem.Upper(), errOutput)
+                }
+            }
+            if list := data.Items; tmtr.IsTrue(list) {
+                for _, v := range list {
+                    tmtr.WriteString(output, v.Upper(), errOutput)
+                }
+            }
+            if with := data.Ptr; tmtr.IsTrue(with) {
+                tmtr.WriteString(output, with.Upper(), errOutput)
+            }
+            x := data.Item()
+            tmtr.WriteString(output, x.Upper(), errOutput)
+            if y := data.Item(); tmtr.IsTrue(y) {
+                tmtr.WriteString(output, y.Upper(), errOutput)
+            }
+        }`,
+	)
+	// errors & calls
+	testTypedFuncOutput(
+		t, ModeText, testTypedSrc,
+		`{{.Load}}{{maybe .Load}}{{call .GetTitle}}{{call .Fn}}{{.Fn}}{{print .Load}}`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            tmtr.WriteString(output, tmtr.MayBe(errOutput, data.Load), errOutput)
+            tmtr.WriteString(output, tmtr.MayBe(errOutput, data.Load), errOutput)
+            tmtr.WriteString(output, data.GetTitle(), errOutput)
+            tmtr.WriteString(output, data.Fn(), errOutput)
+            tmtr.Write(output, data.Fn, errOutput)
+            tmtr.WriteString(output, fmt.Sprint(tmtr.MayBe(errOutput, data.Load)), errOutput)
+        }`,
+	)
+	// diagnostics
+	opts := newTestGeneratorOpts(ModeText, nil, nil, nil)
+	opts.DataType = "data"
+	_, diags, _ := generateFromText("test", "{{.Nope}}\n{{.Prefixed}}", opts, newTestTypeEnv(t, testTypedSrc, opts))
+	util.TestEq(t, diags.Error(), strings.Join([]string{
+		"test:1:3: error: can't evaluate field Nope in type data",
+		"test:2:3: error: wrong number of args for Prefixed: want 1 got 0",
+	}, "\n"))
+}
+
+func TestTypedMaps(t *testing.T) {
+	testTypedFuncOutput(
+		t, ModeText, testTypedSrc,
+		`{{.Meta.Title}}{{.Props.First.Upper}}{{range .Rows}}{{.Total}}{{end}}{{with $m := .Meta}}{{$m.Name}}{{end}}`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            tmtr.WriteString(output, data.Meta["Title"], errOutput)
+            tmtr.WriteString(output, (*data.Props)["First"].Upper(), errOutput)
+            if list := data.Rows; tmtr.IsTrue(list) {
+                for _, elem := range list {
+                    tmtr.WriteInt(output, int64(elem["Total"]), errOutput)
+                }
+            }
+            if m := data.Meta; tmtr.IsTrue(m) {
+                tmtr.WriteString(output, m["Name"], errOutput)
+            }
+        }`,
+	)
+	opts := newTestGeneratorOpts(ModeText, nil, nil, nil)
+	opts.DataType = "data"
+	_, diags, _ := generateFromText("test", "{{.Count.Total}}", opts, newTestTypeEnv(t, testTypedSrc, opts))
+	util.TestEq(t, diags.Error(), "test:1:9: error: can't evaluate field Total in type map[int]int")
+}
+
+func TestTypedDynamic(t *testing.T) {
+	testTypedFuncOutput(
+		t, ModeText, testTypedSrc,
+		`{{.Extra.Name.First}}{{.Extra.Prefixed "x" 1}}{{"y" | .Extra.Prefixed}}{{index .Extra 1 "k"}}{{len .Extra}}{{.Items | len}}`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            tmtr.Write(output, tmtr.Field(errOutput, tmtr.Field(errOutput, data.Extra, "Name"), "First"), errOutput)
+            tmtr.Write(output, tmtr.Method(errOutput, data.Extra, "Prefixed", "x", 1), errOutput)
+            tmtr.Write(output, tmtr.Method(errOutput, data.Extra, "Prefixed", "y"), errOutput)
+            tmtr.Write(output, tmtr.Index(errOutput, data.Extra, 1, "k"), errOutput)
+            tmtr.WriteInt(output, int64(tmtr.Len(errOutput, data.Extra)), errOutput)
+            tmtr.WriteInt(output, int64(len(data.Items)), errOutput)
+        }`,
+	)
+	testTypedFuncOutput(
+		t, ModeText, testTypedSrc,
+		`{{$items := .Items}}{{range $k, $v := .Extra}}{{if $v.Skip}}{{continue}}{{end}}{{range $items}}{{break}}{{end}}{{$v.Name}}{{$.Title}}{{else}}none{{end}}{{range .Extra}}{{break}}{{end}}`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            items := data.Items
+            if list := data.Extra; !tmtr.Range(errOutput, list, 2, func(k, v any) bool {
+                if tmtr.IsTrue(tmtr.Field(errOutput, v, "Skip")) {
+                    return true
+                }
+                if list_ := items; tmtr.IsTrue(list_) {
+                    for range list_ {
+                        break
+                    }
+                }
+                tmtr.Write(output, tmtr.Field(errOutput, v, "Name"), errOutput)
+                tmtr.WriteString(output, data.Title, errOutput)
+                return true
+            }) {
+                tmtr.WriteString(output, "none", errOutput)
+            }
+            if list := data.Extra; tmtr.IsTrue(list) {
+                tmtr.Range(errOutput, list, 0, func(_, elem any) bool {
+                    return false
+                })
+            }
+        }`,
+	)
+}
+
+const testWritesSrc = `package main
+
+import "html/template"
+
+type Status int
+
+func (s Status) String() string { return "" }
+
+type Name string
+
+type data struct {
+	Title  string
+	Name   Name
+	Count  int
+	Size   uint8
+	OK     bool
+	Status Status
+	Body   template.HTML
+	Link   template.URL
+}
+`
+
+func TestTypedWrites(t *testing.T) {
+	testTypedFuncOutput(
+		t, ModeText, testWritesSrc,
+		`{{.Title}}{{.Name}}{{.Count}}{{.Size}}{{.OK}}{{.Status}}{{.Body}}{{len .Title}}`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            tmtr.WriteString(output, data.Title, errOutput)
+            tmtr.WriteString(output, string(data.Name), errOutput)
+            tmtr.WriteInt(output, int64(data.Count), errOutput)
+            tmtr.WriteUint(output, uint64(data.Size), errOutput)
+            tmtr.WriteBool(output, data.OK, errOutput)
+            tmtr.Write(output, data.Status, errOutput)
+            tmtr.WriteString(output, string(data.Body), errOutput)
+            tmtr.WriteInt(output, int64(len(data.Title)), errOutput)
+        }`,
+	)
+	testTypedFuncOutput(
+		t, ModeHTML, testWritesSrc,
+		`<p title="{{.Title}}">{{.Name}}{{.Count}}{{.OK}}{{.Status}}{{.Body}}{{.Link}}</p><a href="{{.Link}}?q={{.Title}}&n={{.Count}}">`,
+		`func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            tmtr.WriteString(output, "<p title=\"", errOutput)
+            tmtr.WriteString(output, tmtr.EscapeHTMLAttrString(data.Title), errOutput)
+            tmtr.WriteString(output, "\">", errOutput)
+            tmtr.WriteString(output, tmtr.EscapeHTMLString(string(data.Name)), errOutput)
+            tmtr.WriteInt(output, int64(data.Count), errOutput)
+            tmtr.WriteBool(output, data.OK, errOutput)
+            tmtr.Write(output, tmtr.EscapeHTML(data.Status), errOutput)
+            tmtr.WriteString(output, string(data.Body), errOutput)
+            tmtr.WriteString(output, tmtr.EscapeHTMLString(string(data.Link)), errOutput)
+            tmtr.WriteString(output, "</p><a href=\"", errOutput)
+            tmtr.WriteString(output, tmtr.EscapeHTMLAttrString(string(data.Link)), errOutput)
+            tmtr.WriteString(output, "?q=", errOutput)
+            tmtr.WriteString(output, tmtr.EscapeHTMLAttrString(tmtr.EscapeURLString(data.Title)), errOutput)
+            tmtr.WriteString(output, "&n=", errOutput)
+            tmtr.WriteInt(output, int64(data.Count), errOutput)
+            tmtr.WriteString(output, "\">", errOutput)
+        }`,
+	)
+}
+
 func TestExternalTemplates(t *testing.T) {
 	infos := []NamedTemplateInfo{
 		{Name: "foo", DataType: "string"},
@@ -1043,9 +1962,9 @@ func TestDefinedTemplates(t *testing.T) {
 	testFuncOutput(
 		t, ModeText,
 		`{{define "foo"}}<p>{{.}}</p>{{end}}{{template "foo" .}}{{define "bar"}}bar{{end}}{{template "bar" .}}`,
-		`func RenderTest(output io.Writer, data any, bar func(io.Writer, any, io.Writer), foo func(io.Writer, any, io.Writer), errOutput io.Writer) {
-            foo(output, data, errOutput)
-            bar(output, data, errOutput)
+		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
+            RenderTestFoo(output, data, errOutput)
+            RenderTestBar(output, data, errOutput)
         }
         func RenderTestBar(output io.Writer, data any, errOutput io.Writer) {
             tmtr.Write(output, "bar", errOutput)
@@ -1059,9 +1978,9 @@ func TestDefinedTemplates(t *testing.T) {
 	testFuncOutput(
 		t, ModeHTML,
 		`{{define "foo"}}<p>{{.}}</p>{{end}}{{template "foo" .}}{{define "bar"}}bar{{end}}{{template "bar" .}}`,
-		`func RenderTest(output io.Writer, data any, bar func(io.Writer, any, io.Writer), foo func(io.Writer, any, io.Writer), errOutput io.Writer) {
-            foo(output, data, errOutput)
-            bar(output, data, errOutput)
+		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
+            RenderTestFoo(output, data, errOutput)
+            RenderTestBar(output, data, errOutput)
         }
         func RenderTestBar(output io.Writer, data any, errOutput io.Writer) {
             tmtr.Write(output, "bar", errOutput)
@@ -1074,6 +1993,22 @@ func TestDefinedTemplates(t *testing.T) {
 	)
 }
 
+func TestUncalledTemplates(t *testing.T) {
+	testFuncOutput(
+		t, ModeHTML,
+		`{{define "foo"}}<p title="{{.}}">{{.}}</p>{{end}}`,
+		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
+        }
+        func RenderTestFoo(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, "<p title=\"", errOutput)
+            tmtr.Write(output, tmtr.EscapeHTMLAttr(data), errOutput)
+            tmtr.Write(output, "\">", errOutput)
+            tmtr.Write(output, tmtr.EscapeHTML(data), errOutput)
+            tmtr.Write(output, "</p>", errOutput)
+        }`,
+	)
+}
+
 func TestNestedTemplates(t *testing.T) {
 	testFuncOutput(
 		t, ModeHTML,
@@ -1083,27 +2018,282 @@ func TestNestedTemplates(t *testing.T) {
 		{{define "T3"}}{{template "T1" .}} {{template "T2" .}}{{end}}
 		{{template "T3" .}}
 		`,
-		`func RenderTest(output io.Writer, data any, T3 func(io.Writer, any, io.Writer), errOutput io.Writer) {
+		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
             tmtr.Write(output, "\n\t\t", errOutput)
             tmtr.Write(output, "\n\t\t", errOutput)
             tmtr.Write(output, "\n\t\t", errOutput)
             tmtr.Write(output, "\n\t\t", errOutput)
-            T3(output, data, errOutput)
+            RenderTestT3(output, data, errOutput)
             tmtr.Write(output, "\n\t\t", errOutput)
         }
         func RenderTestT1(output io.Writer, data any, errOutput io.Writer) {
             tmtr.Write(output, "ONE", errOutput)
         }
-        func RenderTestT2(output io.Writer, data any, T1 func(io.Writer, any, io.Writer), errOutput io.Writer) {
+        func RenderTestT2(output io.Writer, data any, errOutput io.Writer) {
             tmtr.Write(output, "TWO: ", errOutput)
-            T1(output, data, errOutput)
+            RenderTestT1(output, data, errOutput)
         }
-        func RenderTestT3(output io.Writer, data any, T1 func(io.Writer, any, io.Writer), T2 func(io.Writer, any, io.Writer), errOutput io.Writer) {
-            T1(output, data, errOutput)
+        func RenderTestT3(output io.Writer, data any, errOutput io.Writer) {
+            RenderTestT1(output, data, errOutput)
             tmtr.Write(output, " ", errOutput)
-            T2(output, data, errOutput)
+            RenderTestT2(output, data, errOutput)
+        }`,
+	)
+}
+
+func TestRecursiveTemplates(t *testing.T) {
+	testFuncOutput(
+		t, ModeText,
+		`{{define "list"}}{{range .}}{{template "item" .}}{{end}}{{end}}{{define "item"}}{{.Name}}{{template "list" .Children}}{{end}}{{template "list" .}}{{template "item"}}{{template "ext" .}}`,
+		`func RenderTest(output io.Writer, data any, ext func(io.Writer, any, io.Writer), errOutput io.Writer) {
+            RenderTestList(output, data, errOutput)
+            RenderTestItem(output, nil, errOutput)
+            ext(output, data, errOutput)
+        }
+        func RenderTestItem(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, data.Name, errOutput)
+            RenderTestList(output, data.Children, errOutput)
+        }
+        func RenderTestList(output io.Writer, data any, errOutput io.Writer) {
+            if list := data; tmtr.IsTrue(list) {
+                for _, elem := range list {
+                    RenderTestItem(output, elem, errOutput)
+                }
+            }
+        }`,
+	)
+	opts := newTestGeneratorOpts(ModeText, nil, nil, nil)
+	opts.DataType = "int"
+	testOutputWithOpts(
+		t, opts,
+		`{{define "foo"}}{{.}}{{end}}{{template "foo"}}{{template "test" 1}}`,
+		`func RenderTest(output io.Writer, data int, errOutput io.Writer) {
+            RenderTestFoo(output, *new(int), errOutput)
+            RenderTest(output, 1, errOutput)
+        }
+        func RenderTestFoo(output io.Writer, data int, errOutput io.Writer) {
+            tmtr.Write(output, data, errOutput)
+        }`,
+		true, 0,
+	)
+}
+
+func TestBlocks(t *testing.T) {
+	testFuncOutput(
+		t, ModeText,
+		`{{define "side"}}<{{template "nav" .}}>{{end}}{{block "content" .}}[{{block "nav" .Links}}N{{end}}]{{end}}{{template "side" .}}{{- block "title" 1 -}}T{{end}}`,
+		`func RenderTest(output io.Writer, data any, content func(io.Writer, any, io.Writer), nav func(io.Writer, any, io.Writer), title func(io.Writer, any, io.Writer), errOutput io.Writer) {
+            if content != nil {
+                content(output, data, errOutput)
+            } else {
+                RenderTestContent(output, data, nav, errOutput)
+            }
+            RenderTestSide(output, data, nav, errOutput)
+            if title != nil {
+                title(output, 1, errOutput)
+            } else {
+                RenderTestTitle(output, 1, errOutput)
+            }
+        }
+        func RenderTestContent(output io.Writer, data any, nav func(io.Writer, any, io.Writer), errOutput io.Writer) {
+            tmtr.Write(output, "[", errOutput)
+            if nav != nil {
+                nav(output, data.Links, errOutput)
+            } else {
+                RenderTestNav(output, data.Links, errOutput)
+            }
+            tmtr.Write(output, "]", errOutput)
+        }
+        func RenderTestNav(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, "N", errOutput)
+        }
+        func RenderTestSide(output io.Writer, data any, nav func(io.Writer, any, io.Writer), errOutput io.Writer) {
+            tmtr.Write(output, "<", errOutput)
+            if nav != nil {
+                nav(output, data, errOutput)
+            } else {
+                RenderTestNav(output, data, errOutput)
+            }
+            tmtr.Write(output, ">", errOutput)
+        }
+        func RenderTestTitle(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, "T", errOutput)
+        }`,
+	)
+}
+
+func TestDerivedTrees(t *testing.T) {
+	// The private field of the toolchain's html/template
+	_, err := htmlTextField(reflect.TypeFor[ht.Template]())
+	util.TestAssert(t, err == nil)
+	for _, typ := range []reflect.Type{
+		reflect.TypeFor[struct{ Text *tt.Template }](),
+		reflect.TypeFor[struct{ text *ht.Template }](),
+		reflect.TypeFor[struct{}](),
+	} {
+		_, err := htmlTextField(typ)
+		util.TestAssert(t, err != nil && strings.Contains(err.Error(), "has no `text *template.Template` field"))
+	}
+	tmpl := ht.Must(ht.New("test").Parse(`{{define "v"}}{{.}}{{end}}<a title="{{template "v" .}}">`))
+	util.TestAssert(t, tmpl.Execute(io.Discard, nil) == nil)
+	trees, err := derivedTrees(tmpl)
+	util.TestAssert(t, err == nil)
+	util.TestEq(t, len(trees), 1)
+	util.TestAssert(t, trees["v$htmltemplate_stateAttr_delimDoubleQuote"] != nil)
+}
+
+func TestContextVariants(t *testing.T) {
+	testFuncOutput(
+		t, ModeHTML,
+		`{{define "v"}}{{.}}{{end}}<p>{{template "v" .}}</p><a title="{{template "v" .}}" href="/{{template "v" .}}">`,
+		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, "<p>", errOutput)
+            RenderTestV(output, data, errOutput)
+            tmtr.Write(output, "</p><a title=\"", errOutput)
+            RenderTestVInAttrDoubleQuote(output, data, errOutput)
+            tmtr.Write(output, "\" href=\"/", errOutput)
+            RenderTestVInURLDoubleQuotePreQueryURL(output, data, errOutput)
+            tmtr.Write(output, "\">", errOutput)
+        }
+        func RenderTestV(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, tmtr.EscapeHTML(data), errOutput)
+        }
+        func RenderTestVInAttrDoubleQuote(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, tmtr.EscapeHTMLAttr(data), errOutput)
+        }
+        func RenderTestVInURLDoubleQuotePreQueryURL(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, tmtr.EscapeHTMLAttr(tmtr.NormalizeURL(data)), errOutput)
+        }`,
+	)
+	_, diags, err := generateFromText(
+		"test",
+		`{{block "b" .}}{{end}}<a title="{{template "ext" .}}">{{template "b" .}}</a><i title="{{template "b" .}}">`,
+		newTestGeneratorOpts(ModeHTML, nil, nil, nil),
+		nil,
+	)
+	util.TestEq(t, err, nil)
+	util.TestEq(t, diags.Error(), strings.Join([]string{
+		`test:1:44: warning: template "ext" is called in the stateAttr_delimDoubleQuote context, but its output isn't escaped for it`,
+		`test:1:98: warning: block "b" is called in the stateAttr_delimDoubleQuote context, but the output of its override isn't escaped for it`,
+	}, "\n"))
+}
+
+func TestPages(t *testing.T) {
+	testFilesOutput(
+		t, newTestGeneratorOpts(ModeText, nil, nil, nil), nil,
+		[]inputFile{
+			{name: "base.txt", text: `<{{block "title" .}}T{{end}}|{{block "content" .}}C{{template "nav" .}}{{end}}>{{define "nav"}}N{{end}}`},
+			{name: "parts.txt", text: `{{define "footer"}}F{{end}}`},
+			{name: "about.txt", text: `ignored{{define "content"}}about {{template "nav" .}}{{template "footer" .}}{{end}}`},
+			{name: "home.txt", text: `{{define "title"}}home{{end}}{{define "nav"}}n{{end}}`},
+		},
+		`func RenderTest(output io.Writer, data any, content func(io.Writer, any, io.Writer), nav func(io.Writer, any, io.Writer), title func(io.Writer, any, io.Writer), errOutput io.Writer) {
+            tmtr.Write(output, "<", errOutput)
+            if title != nil {
+                title(output, data, errOutput)
+            } else {
+                RenderTestTitle(output, data, errOutput)
+            }
+            tmtr.Write(output, "|", errOutput)
+            if content != nil {
+                content(output, data, errOutput)
+            } else {
+                RenderTestContent(output, data, nav, errOutput)
+            }
+            tmtr.Write(output, ">", errOutput)
+        }
+        func RenderTestAbout(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, "<", errOutput)
+            RenderTestTitle(output, data, errOutput)
+            tmtr.Write(output, "|", errOutput)
+            RenderTestAboutContent(output, data, errOutput)
+            tmtr.Write(output, ">", errOutput)
+        }
+        func RenderTestAboutContent(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, "about ", errOutput)
+            RenderTestNav(output, data, errOutput)
+            RenderTestFooter(output, data, errOutput)
+        }
+        func RenderTestContent(output io.Writer, data any, nav func(io.Writer, any, io.Writer), errOutput io.Writer) {
+            tmtr.Write(output, "C", errOutput)
+            if nav != nil {
+                nav(output, data, errOutput)
+            } else {
+                RenderTestNav(output, data, errOutput)
+            }
+        }
+        func RenderTestFooter(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, "F", errOutput)
+        }
+        func RenderTestHome(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, "<", errOutput)
+            RenderTestHomeTitle(output, data, errOutput)
+            tmtr.Write(output, "|", errOutput)
+            RenderTestContent(output, data, RenderTestHomeNav, errOutput)
+            tmtr.Write(output, ">", errOutput)
+        }
+        func RenderTestHomeNav(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, "n", errOutput)
+        }
+        func RenderTestHomeTitle(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, "home", errOutput)
+        }
+        func RenderTestNav(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, "N", errOutput)
+        }
+        func RenderTestParts(output io.Writer, data any, errOutput io.Writer) {
+        }
+        func RenderTestTitle(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, "T", errOutput)
+        }`,
+		true, 0,
+	)
+	_, _, err := generateFromFiles(
+		[]inputFile{
+			{name: "base.txt", text: `{{block "content" .}}{{end}}`},
+			{name: "a.txt", text: `{{define "content"}}a{{end}}`},
+			{name: "a.tmpl", text: `{{define "content"}}b{{end}}`},
+		},
+		newTestGeneratorOpts(ModeText, nil, nil, nil), nil,
+	)
+	util.TestEq(t, err.Error(), `page "a.tmpl" and page "a.txt" conflict: both are rendered by RenderTestA`)
+}
+
+func TestTemplateNames(t *testing.T) {
+	testFuncOutput(
+		t, ModeText,
+		`{{define "nav-bar"}}N{{end}}{{define "1col"}}C{{end}}{{template "nav-bar" .}}{{template "1col" .}}{{template "ext.html" .}}{{template "2x" .}}`,
+		`func RenderTest(output io.Writer, data any, _2x func(io.Writer, any, io.Writer), extHtml func(io.Writer, any, io.Writer), errOutput io.Writer) {
+            RenderTestNavBar(output, data, errOutput)
+            RenderTest1col(output, data, errOutput)
+            extHtml(output, data, errOutput)
+            _2x(output, data, errOutput)
+        }
+        func RenderTest1col(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, "C", errOutput)
+        }
+        func RenderTestNavBar(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, "N", errOutput)
         }`,
 	)
+	opts := newTestGeneratorOpts(ModeText, nil, nil, nil)
+	opts.FnNames = map[string]string{"nav-bar": "renderNav"}
+	testOutputWithOpts(
+		t, opts,
+		`{{define "nav-bar"}}N{{end}}{{template "nav-bar" .}}`,
+		`func RenderTest(output io.Writer, data any, errOutput io.Writer) {
+            renderNav(output, data, errOutput)
+        }
+        func renderNav(output io.Writer, data any, errOutput io.Writer) {
+            tmtr.Write(output, "N", errOutput)
+        }`,
+		true, 0,
+	)
+	opts.FnNames = map[string]string{"nav": "renderNav"}
+	_, _, err := generateFromText("test", `{{define "nav-bar"}}N{{end}}`, opts, nil)
+	util.TestEq(t, err.Error(), `can't name the function of "nav": no such template in the layout or page`)
+	_, _, err = generateFromText("test", `{{define "foo-bar"}}{{end}}{{define "fooBar"}}{{end}}`, newTestGeneratorOpts(ModeText, nil, nil, nil), nil)
+	util.TestEq(t, err.Error(), `"foo-bar" and "fooBar" conflict: both are rendered by RenderTestFooBar`)
 }
 
 func TestInternalImports(t *testing.T) {
@@ -1138,19 +2328,54 @@ func TestInternalImports(t *testing.T) {
 	)
 }
 
+func TestImportNames(t *testing.T) {
+	for path, name := range map[string]string{
+		"math":                       "math",
+		"net/http":                   "http",
+		"gopkg.in/yaml.v3":           "yaml",
+		"example.com/foo/v2":         "foo",
+		"github.com/a/go-isatty":     "go_isatty",
+		"example.com/v2":             "example",
+		"github.com/a/b/v2/internal": "internal",
+	} {
+		util.TestEq(t, guessImportName(path), name)
+	}
+	// The names of the loaded packages
+	opts := newTestGeneratorOpts(ModeText, nil, []string{"math/rand/v2", "text/template/parse"}, nil)
+	opts.DataType = "data"
+	env := newTestTypeEnv(t, testTypedSrc, opts)
+	util.TestEq(t, env.importName("math/rand/v2"), "rand")
+	util.TestAssert(t, env.imports["rand"] != nil)
+	util.TestEq(t, env.importName("text/template/parse"), "parse")
+	testOutputWithEnv(
+		t, opts, env,
+		`{{rand.IntN 1}}`,
+		`package main
+
+        import (
+            io "io"
+            rand "math/rand/v2"
+            tmtr "`+FuncsPkgPath+`"
+        )
+
+        func RenderTest(output io.Writer, data data, errOutput io.Writer) {
+            tmtr.WriteInt(output, int64(rand.IntN(1)), errOutput)
+        }`,
+		false, 1,
+	)
+}
+
 func TestExternalImports(t *testing.T) {
+	// The unused ones are omitted
 	testOutputWithOpts(
 		t, newTestGeneratorOpts(ModeText, nil, []string{"math", "net", "net/http", "unicode/utf16"}, nil),
 		`{{math.Max 1 2}}`,
 		`package main
         
         import (
-            http "net/http"
             io "io"
             math "math"
-            net "net"
 			tmtr "`+FuncsPkgPath+`"
-            utf16 "unicode/utf16"
         )
         
         func RenderTest(output io.Writer, data any, errOutput io.Writer) {
@@ -1174,21 +2399,21 @@ func TestExternalImports(t *testing.T) {
         }`,
 		false, 0,
 	)
-	// The "data" import conflicts with the "data" argument. The generator *doesn't*
-	// rename "data.Data" to "data_.Data", because it doesn't know a user intention.
+	// The "data" import conflicts with the "data" argument. The imports are
+	// reserved up front, so the argument is renamed, and "data.Data" is still
+	// the import's one. The unused "output" one is reserved too.
 	testOutputWithOpts(
 		t, newTestGeneratorOpts(ModeHTML, nil, []string{"output", "data"}, nil),
 		`{{data.Data}}`,
 		`package main
 
         import (
-            data_ "data"
+            data "data"
             io "io"
-            output "output"
 			tmtr "`+FuncsPkgPath+`"
         )
 
-        func RenderTest(output_ io.Writer, data any, errOutput io.Writer) {
+        func RenderTest(output_ io.Writer, data_ any, errOutput io.Writer) {
             tmtr.Write(output_, tmtr.EscapeHTML(data.Data), errOutput)
         }`,
 		false, 0,
@@ -1260,8 +2485,46 @@ func testFuncOutput(t *testing.T, mode Mode, tmpl, expected string) {
 	)
 }
 
+// Generates the typed code for the "data" type declared by the source.
+func testTypedFuncOutput(t *testing.T, mode Mode, src, tmpl, expected string) {
+	opts := newTestGeneratorOpts(mode, nil, nil, nil)
+	opts.DataType = "data"
+	testOutputWithEnv(
+		t, opts, newTestTypeEnv(t, src, opts),
+		tmpl, expected, true,
+		1,
+	)
+}
+
+// Type-checks the source along with the stub (see `typeEnvStub`).
+func newTestTypeEnv(t *testing.T, src string, opts GeneratorOptions) *typeEnv {
+	fset := token.NewFileSet()
+	files := make([]*ast.File, 0, 2)
+	for _, s := range []string{src, typeEnvStub(opts)} {
+		f, err := parser.ParseFile(fset, "", s, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error:    func(error) {},
+	}
+	pkg, _ := conf.Check("test", fset, files, nil)
+	return newTypeEnv(pkg, opts, &diagnostics{})
+}
+
 func testOutputWithOpts(t *testing.T, opts GeneratorOptions, tmpl, expected string, funcOnly bool, depth int) {
-	f, err := generateFromText("test", tmpl, opts)
+	testOutputWithEnv(t, opts, nil, tmpl, expected, funcOnly, depth+1)
+}
+
+func testOutputWithEnv(t *testing.T, opts GeneratorOptions, env *typeEnv, tmpl, expected string, funcOnly bool, depth int) {
+	testFilesOutput(t, opts, env, []inputFile{{name: "test", text: tmpl}}, expected, funcOnly, depth+1)
+}
+
+func testFilesOutput(t *testing.T, opts GeneratorOptions, env *typeEnv, files []inputFile, expected string, funcOnly bool, depth int) {
+	f, _, err := generateFromFiles(files, opts, env)
 	if err != nil {
 		t.Error(err)
 		return
@@ -1275,6 +2538,9 @@ func testOutputWithOpts(t *testing.T, opts GeneratorOptions, tmpl, expected stri
 		t.Error(err)
 	}
 	actual := buf.String()
+	if opts.LineDirectives {
+		actual = string(FixLineDirectives([]byte(actual), "test.go"))
+	}
 	if funcOnly {
 		actual = actual[strings.Index(actual, "func Render"):]
 	}
@@ -1285,7 +2551,11 @@ func testOutputWithOpts(t *testing.T, opts GeneratorOptions, tmpl, expected stri
 	}
 	expected = strings.Join(exp, "\n")
 	if actual != expected+"\n" {
-		t.Logf("\n>>> INPUT >>>\n\n%s\n\n>>> ACTUAL >>>\n\n%s\n<<< EXPECTED <<<\n\n%s", tmpl, actual, expected)
+		var tmpl strings.Builder
+		for _, f := range files {
+			fmt.Fprintf(&tmpl, "// %s\n%s\n", f.name, f.text)
+		}
+		t.Logf("\n>>> INPUT >>>\n\n%s\n\n>>> ACTUAL >>>\n\n%s\n<<< EXPECTED <<<\n\n%s", tmpl.String(), actual, expected)
 		util.Fail(t, depth+1)
 	}
 }
@@ -8,14 +8,37 @@ var (
 	sprintIdent          = ast.NewIdent("Sprint")
 	sprintfIdent         = ast.NewIdent("Sprintf")
 	sprintlnIdent        = ast.NewIdent("Sprintln")
-	andIdent             = ast.NewIdent("And")
-	orIdent              = ast.NewIdent("Or")
 	isTrueIdent          = ast.NewIdent("IsTrue")
 	isNotTrueIdent       = ast.NewIdent("IsNotTrue")
 	htmlEscaperIdent     = ast.NewIdent("HTMLEscaper")
 	jsEscaperIdent       = ast.NewIdent("JSEscaper")
 	urlQueryEscaperIdent = ast.NewIdent("URLQueryEscaper")
 	maybeIdent           = ast.NewIdent("MayBe")
+	resultIdent          = ast.NewIdent("Result")
+	fieldIdent           = ast.NewIdent("Field")
+	methodIdent          = ast.NewIdent("Method")
+	indexIdent           = ast.NewIdent("Index")
+	lenIdent             = ast.NewIdent("Len")
+	rangeIdent           = ast.NewIdent("Range")
+	sortedKeysIdent      = ast.NewIdent("SortedKeys")
+	sortedBoolKeysIdent  = ast.NewIdent("SortedBoolKeys")
+	eqIdent              = ast.NewIdent("Eq")
+	neIdent              = ast.NewIdent("Ne")
+	ltIdent              = ast.NewIdent("Lt")
+	leIdent              = ast.NewIdent("Le")
+	gtIdent              = ast.NewIdent("Gt")
+	geIdent              = ast.NewIdent("Ge")
+	notNilIdent          = ast.NewIdent("NotNil")
+	inRangeIdent         = ast.NewIdent("InRange")
+	sliceInRangeIdent    = ast.NewIdent("SliceInRange")
+	recoverIdent         = ast.NewIdent("Recover")
+	checkIdent           = ast.NewIdent("Check")
+	executeIdent         = ast.NewIdent("Execute")
+	newOutputIdent       = ast.NewIdent("NewOutput")
+	writeStringIdent     = ast.NewIdent("WriteString")
+	writeIntIdent        = ast.NewIdent("WriteInt")
+	writeUintIdent       = ast.NewIdent("WriteUint")
+	writeBoolIdent       = ast.NewIdent("WriteBool")
 
 	escapeHTMLAttrIdent         = ast.NewIdent("EscapeHTMLAttr")
 	escapeCommentIdent          = ast.NewIdent("EscapeComment")
@@ -33,4 +56,20 @@ var (
 	escapeURLIdent              = ast.NewIdent("EscapeURL")
 	filterURLIdent              = ast.NewIdent("FilterURL")
 	normalizeURLIdent           = ast.NewIdent("NormalizeURL")
+
+	escapeHTMLAttrStringIdent         = ast.NewIdent("EscapeHTMLAttrString")
+	escapeCSSStringIdent              = ast.NewIdent("EscapeCSSString")
+	filterCSSStringIdent              = ast.NewIdent("FilterCSSString")
+	filterHTMLTagContentStringIdent   = ast.NewIdent("FilterHTMLTagContentString")
+	escapeHTMLStringIdent             = ast.NewIdent("EscapeHTMLString")
+	escapeJSRegexpStringIdent         = ast.NewIdent("EscapeJSRegexpString")
+	escapeJSStrStringIdent            = ast.NewIdent("EscapeJSStrString")
+	escapeJSTmplLitStringIdent        = ast.NewIdent("EscapeJSTmplLitString")
+	escapeJSStringIdent               = ast.NewIdent("EscapeJSString")
+	escapeUnquotedHTMLAttrStringIdent = ast.NewIdent("EscapeUnquotedHTMLAttrString")
+	escapeRCDataStringIdent           = ast.NewIdent("EscapeRCDataString")
+	filterAndEscapeSrcsetStringIdent  = ast.NewIdent("FilterAndEscapeSrcsetString")
+	escapeURLStringIdent              = ast.NewIdent("EscapeURLString")
+	filterURLStringIdent              = ast.NewIdent("FilterURLString")
+	normalizeURLStringIdent           = ast.NewIdent("NormalizeURLString")
 )
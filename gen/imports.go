@@ -4,45 +4,119 @@ import (
 	"go/ast"
 	"go/token"
 	"sort"
-
-	"github.com/apleshkov/tmtr/scopes"
+	"strings"
 )
 
+// The imports are named up front, so their names are reserved in the scopes,
+// and the template variables can't shadow them. Only the used ones are
+// declared.
 type imports struct {
-	m map[string]*ast.ImportSpec
+	m    map[string]*ast.ImportSpec // by paths
+	used map[string]bool
+}
+
+// The user imports keep their names, cause the templates refer to them (e.g.
+// `{{strings.ToUpper .}}`), so the runtime ones are renamed in case of
+// conflict (e.g. "tmtr_").
+func newImports(paths []string, env *typeEnv) *imports {
+	imp := &imports{
+		m:    make(map[string]*ast.ImportSpec),
+		used: make(map[string]bool),
+	}
+	for _, path := range paths {
+		imp.add(env.importName(path), path)
+	}
+	imp.add(ioPkg, ioPkg)
+	imp.add(fmtPkg, fmtPkg)
+	imp.add(htPkg, htPkgPath)
+	imp.add(funcsPkg, FuncsPkgPath)
+	return imp
 }
 
-func newImports() *imports {
-	return &imports{
-		m: make(map[string]*ast.ImportSpec),
+// Returns the package name by the import path's convention: e.g. "http" for
+// "net/http", "yaml" for "gopkg.in/yaml.v3", and "foo" for
+// "example.com/foo/v2".
+func guessImportName(path string) string {
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && isMajorVersion(name) {
+		name = elems[len(elems)-2]
 	}
+	name, _, _ = strings.Cut(name, ".")
+	return strings.ReplaceAll(name, "-", "_")
 }
 
-func (imp *imports) use(key, path string, in scopes.Scope) *ast.Ident {
-	if s, ok := imp.m[key]; ok {
-		return s.Name
+// Reports if it's a major version suffix, e.g. "v2".
+func isMajorVersion(s string) bool {
+	if len(s) < 2 || s[0] != 'v' {
+		return false
+	}
+	for _, c := range s[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
 	}
-	n := scopes.Uniq(in, key)
-	s := &ast.ImportSpec{
-		Name: n,
+	return true
+}
+
+func (imp *imports) add(name, path string) {
+	if _, ok := imp.m[path]; ok {
+		return
+	}
+	for imp.has(name) {
+		name += "_"
+	}
+	imp.m[path] = &ast.ImportSpec{
+		Name: ast.NewIdent(name),
 		Path: &ast.BasicLit{
 			Kind:  token.STRING,
 			Value: "\"" + path + "\"",
 		},
 	}
-	imp.m[key] = s
-	return n
 }
 
-func (imp *imports) decls() *ast.GenDecl {
-	ks := make([]string, 0, len(imp.m))
-	for k := range imp.m {
-		ks = append(ks, k)
+func (imp *imports) has(name string) bool {
+	for _, s := range imp.m {
+		if s.Name.Name == name {
+			return true
+		}
 	}
-	sort.Strings(ks)
-	specs := make([]ast.Spec, len(ks))
-	for i, k := range ks {
-		specs[i] = imp.m[k]
+	return false
+}
+
+// Returns the names of all the imports, even unused.
+func (imp *imports) names() []string {
+	names := make([]string, 0, len(imp.m))
+	for _, s := range imp.m {
+		names = append(names, s.Name.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (imp *imports) use(path string) *ast.Ident {
+	imp.used[path] = true
+	return imp.m[path].Name
+}
+
+// Marks the import as used if the template refers to it by the name (e.g.
+// "strings" in `{{strings.ToUpper .}}`).
+func (imp *imports) useNamed(name string) {
+	for path, s := range imp.m {
+		if s.Name.Name == name {
+			imp.used[path] = true
+		}
+	}
+}
+
+func (imp *imports) decls() *ast.GenDecl {
+	specs := make([]ast.Spec, 0, len(imp.used))
+	for _, name := range imp.names() {
+		for path, s := range imp.m {
+			if s.Name.Name == name && imp.used[path] {
+				specs = append(specs, s)
+			}
+		}
 	}
 	return &ast.GenDecl{
 		Tok:   token.IMPORT,
@@ -50,20 +124,20 @@ func (imp *imports) decls() *ast.GenDecl {
 	}
 }
 
-func (g *Generator) useIO(in scopes.Scope) *ast.Ident {
-	return g.imports.use(ioPkg, ioPkg, in)
+func (g *Generator) useIO() *ast.Ident {
+	return g.imports.use(ioPkg)
 }
 
-func (g *Generator) useFmt(in scopes.Scope) *ast.Ident {
-	return g.imports.use(fmtPkg, fmtPkg, in)
+func (g *Generator) useFmt() *ast.Ident {
+	return g.imports.use(fmtPkg)
 }
 
-func (g *Generator) useHTMLTemplate(in scopes.Scope) *ast.Ident {
-	return g.imports.use(htPkg, htPkgPath, in)
+func (g *Generator) useHTMLTemplate() *ast.Ident {
+	return g.imports.use(htPkgPath)
 }
 
-func (g *Generator) useFuncs(in scopes.Scope) *ast.Ident {
-	return g.imports.use(funcsPkg, FuncsPkgPath, in)
+func (g *Generator) useFuncs() *ast.Ident {
+	return g.imports.use(FuncsPkgPath)
 }
 
 const (
@@ -0,0 +1,126 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+// There's no way to attach a comment to an unpositioned statement, so
+// comments and `//line` directives are generated as "raw" statements,
+// which are printed as is.
+func rawStmt(s string) ast.Stmt {
+	return &ast.ExprStmt{X: &ast.BasicLit{Value: s}}
+}
+
+// Returns the statements to put before the node's one: an optional
+// comment with the template snippet and an optional `//line` directive.
+func (g *Generator) nodeMarkers(n parse.Node) []ast.Stmt {
+	if !g.lineDirectives && !g.comments {
+		return nil
+	}
+	switch n.(type) {
+	case *parse.CommentNode:
+		return nil
+	}
+	stmts := make([]ast.Stmt, 0, 2)
+	if g.comments {
+		if _, ok := n.(*parse.TextNode); !ok {
+			if s := g.snippet(n); len(s) > 0 {
+				stmts = append(stmts, rawStmt("// "+s))
+			}
+		}
+	}
+	if g.lineDirectives {
+		if d := nodeDiagnostic(g.tree, n); d.Line > 0 {
+			stmts = append(stmts, rawStmt(fmt.Sprintf("//line %s:%d:%d", d.File, d.Line, d.Col)))
+		}
+	}
+	return stmts
+}
+
+// Returns the node's action as it's written in the source (e.g. "{{if .}}"
+// for an if node), but with whitespaces collapsed.
+func (g *Generator) snippet(n parse.Node) string {
+	src := g.src
+	pos := int(n.Position())
+	if pos <= 0 || pos > len(src) {
+		return ""
+	}
+	start := strings.LastIndex(src[:pos], "{{")
+	if start == -1 {
+		return ""
+	}
+	end := strings.Index(src[pos:], "}}")
+	if end == -1 {
+		return ""
+	}
+	s := src[start:(pos + end + 2)]
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// Moves `//line` directives to the beginning of a line, cause the
+// compiler ignores them otherwise, and resets the position before a
+// function declaration following a directive, so the declaration points
+// at the generated file (`name` is its name). The compiler counts columns
+// from the beginning of the next line, so its indentation is subtracted.
+func FixLineDirectives(src []byte, name string) []byte {
+	lines := bytes.Split(src, []byte("\n"))
+	res := make([][]byte, 0, len(lines))
+	directed := false
+	for i, l := range lines {
+		if directed && bytes.HasPrefix(l, []byte("func ")) {
+			// the directive's line is len(res) + 1
+			res = append(res, fmt.Appendf(nil, "//line %s:%d", name, len(res)+2))
+			directed = false
+		}
+		if t := bytes.TrimLeft(l, " \t"); bytes.HasPrefix(t, []byte("//line ")) {
+			l = t
+			if i+1 < len(lines) {
+				next := lines[i+1]
+				indent := len(next) - len(bytes.TrimLeft(next, " \t"))
+				l = shiftLineDirective(l, indent)
+			}
+			directed = true
+		}
+		res = append(res, l)
+	}
+	return bytes.Join(res, []byte("\n"))
+}
+
+// Subtracts `n` from the directive's column if any. The column is omitted
+// if it becomes non-positive.
+func shiftLineDirective(d []byte, n int) []byte {
+	i := bytes.LastIndexByte(d, ':')
+	j := bytes.LastIndexByte(d[:max(i, 0)], ':')
+	if j == -1 {
+		return d
+	}
+	col, err := strconv.Atoi(string(d[(i + 1):]))
+	if err != nil {
+		return d
+	}
+	if _, err := strconv.Atoi(string(d[(j + 1):i])); err != nil {
+		// "//line file:line" (the filename contains a colon)
+		return d
+	}
+	if col -= n; col > 0 {
+		return fmt.Appendf(d[:(i+1):(i+1)], "%d", col)
+	}
+	return d[:i]
+}
+
+// Removes the `//line` directives moved by `FixLineDirectives`.
+func StripLineDirectives(src []byte) []byte {
+	lines := bytes.SplitAfter(src, []byte("\n"))
+	res := make([][]byte, 0, len(lines))
+	for _, l := range lines {
+		if !bytes.HasPrefix(l, []byte("//line ")) {
+			res = append(res, l)
+		}
+	}
+	return bytes.Join(res, nil)
+}
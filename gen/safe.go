@@ -0,0 +1,298 @@
+package gen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"text/template/parse"
+
+	"github.com/apleshkov/tmtr/scopes"
+)
+
+// In the safe mode the nil pointers (and interfaces) of field chains and
+// the bounds of `index` and `slice` are checked before the action, like
+// text/template does while executing, so a failed action writes the error
+// to `errOutput` and nothing else instead of panicking:
+//
+//	if tmtr.NotNil(errOutput, "index.html:1:3", data.User == nil, "*User.Name") {
+//		tmtr.WriteString(output, data.User.Name, errOutput)
+//	}
+//
+// The checks are collected while generating the node's expressions. They
+// evaluate the checked values again, so the results of function and method
+// calls aren't checked (the types are also required).
+func (g *Generator) guardedStmts(n parse.Node, scope scopes.Scope) []ast.Stmt {
+	if !g.safe {
+		return []ast.Stmt{g.nodeStmt(n, scope)}
+	}
+	outer := g.guards
+	g.guards = nil
+	stmt := g.nodeStmt(n, scope)
+	guards := g.guards
+	g.guards = outer
+	if len(guards) == 0 {
+		return []ast.Stmt{stmt}
+	}
+	cond := guardsExpr(guards)
+	if a, ok := stmt.(*ast.AssignStmt); ok && a.Tok == token.DEFINE {
+		return g.guardedDeclStmts(a, cond, n)
+	}
+	return []ast.Stmt{&ast.IfStmt{
+		Cond: cond,
+		Body: &ast.BlockStmt{List: []ast.Stmt{stmt}},
+	}}
+}
+
+// Declares the variables before the check, so they're visible to the
+// following nodes, and assigns them if passed: `var x T; if ... { x = ... }`.
+func (g *Generator) guardedDeclStmts(a *ast.AssignStmt, cond ast.Expr, n parse.Node) []ast.Stmt {
+	specs := make([]ast.Spec, 0, len(a.Lhs))
+	for _, x := range a.Lhs {
+		id := x.(*ast.Ident)
+		t := g.typeExpr(g.typeOf(id))
+		if t == nil {
+			g.warnf(n, "can't check the value of $%s, cause its type can't be referred to", id.Name)
+			return []ast.Stmt{a}
+		}
+		specs = append(specs, &ast.ValueSpec{
+			Names: []*ast.Ident{id},
+			Type:  t,
+		})
+	}
+	return []ast.Stmt{
+		&ast.DeclStmt{
+			Decl: &ast.GenDecl{
+				Tok:   token.VAR,
+				Specs: specs,
+			},
+		},
+		&ast.IfStmt{
+			Cond: cond,
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.AssignStmt{
+						Tok: token.ASSIGN,
+						Lhs: a.Lhs,
+						Rhs: a.Rhs,
+					},
+				},
+			},
+		},
+	}
+}
+
+func guardsExpr(guards []ast.Expr) ast.Expr {
+	cond := guards[0]
+	for _, x := range guards[1:] {
+		cond = &ast.BinaryExpr{
+			Op: token.LAND,
+			X:  cond,
+			Y:  x,
+		}
+	}
+	return cond
+}
+
+// Checks `x` isn't nil before selecting `name` (`t` is its type).
+func (g *Generator) nilGuard(x ast.Expr, t types.Type, name string, at parse.Node) {
+	if !g.safe || !isPure(x) {
+		return
+	}
+	g.guards = append(g.guards, &ast.CallExpr{
+		Fun: &ast.SelectorExpr{
+			X:   g.useFuncs(),
+			Sel: notNilIdent,
+		},
+		Args: []ast.Expr{
+			g.eoutIdent,
+			g.posLit(at),
+			&ast.BinaryExpr{
+				Op: token.EQL,
+				X:  x,
+				Y:  nilIdent,
+			},
+			&ast.BasicLit{
+				Kind:  token.STRING,
+				Value: strconv.Quote(g.env.typeString(t) + "." + name),
+			},
+		},
+	})
+}
+
+// Checks `i` is within the bounds of `x` (`t` is its type) before
+// `x[i]`. Maps aren't checked, cause a missing key gives the zero value.
+func (g *Generator) indexGuard(x, i ast.Expr, t types.Type, at parse.Node) {
+	if !g.safe || !isPure(x) || !isPure(i) || t == nil {
+		return
+	}
+	if _, ok := t.Underlying().(*types.Map); ok {
+		return
+	}
+	g.guards = append(g.guards, &ast.CallExpr{
+		Fun: &ast.SelectorExpr{
+			X:   g.useFuncs(),
+			Sel: inRangeIdent,
+		},
+		Args: []ast.Expr{g.eoutIdent, g.posLit(at), g.intExpr(i), lenExpr("len", x)},
+	})
+}
+
+// Checks the indexes of `x[i:j:k]` (`t` is the type of `x`).
+func (g *Generator) sliceGuard(x ast.Expr, idx []ast.Expr, t types.Type, at parse.Node) {
+	if !g.safe || !isPure(x) || t == nil || len(idx) == 0 {
+		return
+	}
+	args := []ast.Expr{g.eoutIdent, g.posLit(at)}
+	// `x[i:]` is `x[i:len(x)]`, and strings have no capacity
+	if b, ok := t.Underlying().(*types.Basic); len(idx) == 1 || ok && b.Info()&types.IsString != 0 {
+		args = append(args, lenExpr("len", x))
+	} else {
+		args = append(args, lenExpr("cap", x))
+	}
+	for _, i := range idx {
+		if !isPure(i) {
+			return
+		}
+		args = append(args, g.intExpr(i))
+	}
+	g.guards = append(g.guards, &ast.CallExpr{
+		Fun: &ast.SelectorExpr{
+			X:   g.useFuncs(),
+			Sel: sliceInRangeIdent,
+		},
+		Args: args,
+	})
+}
+
+// Returns the position of the node as a string literal, e.g.
+// "index.html:1:3".
+func (g *Generator) posLit(n parse.Node) ast.Expr {
+	d := nodeDiagnostic(g.tree, n)
+	s := d.File
+	if d.Line > 0 {
+		s = fmt.Sprintf("%s:%d:%d", d.File, d.Line, d.Col)
+	}
+	return &ast.BasicLit{
+		Kind:  token.STRING,
+		Value: strconv.Quote(s),
+	}
+}
+
+// Converts the index to `int` unless it's a constant or an `int` already.
+func (g *Generator) intExpr(x ast.Expr) ast.Expr {
+	if _, ok := x.(*ast.BasicLit); ok {
+		return x
+	}
+	if t := g.typeOf(x); t != nil && types.Identical(t, types.Typ[types.Int]) {
+		return x
+	}
+	return &ast.CallExpr{
+		Fun:  ast.NewIdent("int"),
+		Args: []ast.Expr{x},
+	}
+}
+
+func lenExpr(fn string, x ast.Expr) ast.Expr {
+	return &ast.CallExpr{
+		Fun:  ast.NewIdent(fn),
+		Args: []ast.Expr{x},
+	}
+}
+
+// Reports if evaluating the expression again has no side effects and
+// costs nothing (e.g. it's a field chain).
+func isPure(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.Ident, *ast.BasicLit:
+		return true
+	case *ast.SelectorExpr:
+		return isPure(x.X)
+	case *ast.IndexExpr:
+		return isPure(x.X) && isPure(x.Index)
+	case *ast.ParenExpr:
+		return isPure(x.X)
+	case *ast.StarExpr:
+		return isPure(x.X)
+	}
+	return false
+}
+
+// The panics are recovered by the generated functions, so the output has
+// everything written before the failed action, and the rest is skipped:
+//
+//	pos := "index.html:1:1"
+//	defer tmtr.Recover(errOutput, &pos)
+//	...
+//	pos = "index.html:3:5"
+//	tmtr.Write(output, data.Method(), errOutput)
+//
+// The actions writing values (or assigning variables) are also wrapped in
+// the `RecoverAction` mode, so a failed one writes nothing and the
+// rendering goes on:
+//
+//	pos = "index.html:3:5"
+//	func() {
+//		defer tmtr.Recover(errOutput, &pos)
+//		tmtr.Write(output, data.Method(), errOutput)
+//	}()
+//
+// The declarations aren't wrapped, cause their variables are used by the
+// following nodes.
+func (g *Generator) recoverStmts(n parse.Node, stmts []ast.Stmt) []ast.Stmt {
+	if g.posIdent == nil {
+		return stmts
+	}
+	switch n.(type) {
+	case *parse.TextNode, *parse.CommentNode, *parse.BreakNode, *parse.ContinueNode:
+		return stmts
+	}
+	pos := &ast.AssignStmt{
+		Tok: token.ASSIGN,
+		Lhs: []ast.Expr{g.posIdent},
+		Rhs: []ast.Expr{g.posLit(n)},
+	}
+	if a, ok := n.(*parse.ActionNode); !ok || g.recoverMode != RecoverAction || len(a.Pipe.Decl) > 0 && !a.Pipe.IsAssign {
+		return append([]ast.Stmt{pos}, stmts...)
+	}
+	return []ast.Stmt{
+		pos,
+		exprStmt(&ast.CallExpr{
+			Fun: &ast.FuncLit{
+				Type: &ast.FuncType{Params: &ast.FieldList{}},
+				Body: &ast.BlockStmt{
+					List: append([]ast.Stmt{g.deferRecoverStmt()}, stmts...),
+				},
+			},
+		}),
+	}
+}
+
+// Returns the function's `pos` declaration (see `recoverStmts`) and the
+// deferred recover.
+func (g *Generator) deferRecoverStmts(root parse.Node) []ast.Stmt {
+	return []ast.Stmt{
+		&ast.AssignStmt{
+			Tok: token.DEFINE,
+			Lhs: []ast.Expr{g.posIdent},
+			Rhs: []ast.Expr{g.posLit(root)},
+		},
+		g.deferRecoverStmt(),
+	}
+}
+
+func (g *Generator) deferRecoverStmt() ast.Stmt {
+	return &ast.DeferStmt{
+		Call: &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   g.useFuncs(),
+				Sel: recoverIdent,
+			},
+			Args: []ast.Expr{
+				g.eoutIdent,
+				&ast.UnaryExpr{Op: token.AND, X: g.posIdent},
+			},
+		},
+	}
+}
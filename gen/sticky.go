@@ -0,0 +1,65 @@
+package gen
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// The output is wrapped by `tmtr.NewOutput`, so after the first failed write
+// the error is written to `errOutput` once, the following writes are
+// skipped, and the loops exit early:
+//
+//	out := tmtr.NewOutput(output)
+//	for _, v := range data.Items {
+//		if out.Failed() {
+//			break
+//		}
+//		tmtr.WriteString(out, v.Name, errOutput)
+//	}
+//
+// The nested templates get the wrapped output, so they share its state.
+func (g *Generator) stickyOutputStmt(output *ast.Ident) ast.Stmt {
+	return &ast.AssignStmt{
+		Tok: token.DEFINE,
+		Lhs: []ast.Expr{g.outIdent},
+		Rhs: []ast.Expr{
+			&ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   g.useFuncs(),
+					Sel: newOutputIdent,
+				},
+				Args: []ast.Expr{output},
+			},
+		},
+	}
+}
+
+// Exits the loop if the output has failed (see `stickyOutputStmt`).
+func (g *Generator) failedOutputStmt() ast.Stmt {
+	var exit ast.Stmt = &ast.BranchStmt{Tok: token.BREAK}
+	if g.inDynamicRange() {
+		exit = &ast.ReturnStmt{Results: []ast.Expr{ast.NewIdent("false")}}
+	}
+	return &ast.IfStmt{
+		Cond: &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   g.outIdent,
+				Sel: ast.NewIdent("Failed"),
+			},
+		},
+		Body: &ast.BlockStmt{List: []ast.Stmt{exit}},
+	}
+}
+
+// Reports if the identifier is used in the node (by pointer, see
+// `dropUnusedVars`).
+func usesIdent(n ast.Node, id *ast.Ident) bool {
+	found := false
+	ast.Inspect(n, func(n ast.Node) bool {
+		if n == id {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
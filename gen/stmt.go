@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"go/ast"
 	"go/token"
-	"strings"
+	"go/types"
+	"slices"
+	"strconv"
 	"text/template/parse"
 
 	"github.com/apleshkov/tmtr/scopes"
@@ -14,10 +16,10 @@ import (
 func (g *Generator) nodeStmt(n parse.Node, scope scopes.Scope) ast.Stmt {
 	if n, ok := n.(*parse.TextNode); ok {
 		text := string(n.Text)
-		return g.writeUnescapedExprStmt(&ast.BasicLit{
+		return g.writeUnescapedExprStmt(g.typed(&ast.BasicLit{
 			Kind:  token.STRING,
 			Value: fmt.Sprintf("%q", text),
-		}, scope)
+		}, types.Typ[types.String]), scope)
 	}
 	if _, ok := n.(*parse.CommentNode); ok {
 		return &ast.EmptyStmt{}
@@ -26,19 +28,24 @@ func (g *Generator) nodeStmt(n parse.Node, scope scopes.Scope) ast.Stmt {
 		return g.actionNodeStmt(n, scope)
 	}
 	if _, ok := n.(*parse.BreakNode); ok {
+		if g.inDynamicRange() {
+			return &ast.ReturnStmt{Results: []ast.Expr{ast.NewIdent("false")}}
+		}
 		return &ast.BranchStmt{Tok: token.BREAK}
 	}
 	if _, ok := n.(*parse.ContinueNode); ok {
+		if g.inDynamicRange() {
+			return &ast.ReturnStmt{Results: []ast.Expr{ast.NewIdent("true")}}
+		}
 		return &ast.BranchStmt{Tok: token.CONTINUE}
 	}
 	if n, ok := n.(*parse.IfNode); ok {
 		ifScope := scopes.NewIfScope(scope, n)
 		thenScope := ifScope.ThenScope
-		body := g.listNodeStmt(n.List, thenScope)
 		pipe := n.Pipe
 		var stmt *ast.IfStmt
-		if pipe.Decl != nil {
-			assign := g.pipeAssignStmt(pipe, scope)
+		if len(pipe.Decl) > 0 {
+			assign := g.pipeAssignStmt(pipe, scope, thenScope)
 			cond := g.nonEmptyCond(assign.Lhs[0], scope)
 			for _, x := range assign.Lhs[1:] {
 				cond = &ast.BinaryExpr{
@@ -50,56 +57,31 @@ func (g *Generator) nodeStmt(n parse.Node, scope scopes.Scope) ast.Stmt {
 			stmt = &ast.IfStmt{
 				Init: assign,
 				Cond: cond,
-				Body: body,
 			}
 		} else {
 			stmt = &ast.IfStmt{
 				Cond: g.nonEmptyCond(
-					g.cmdsExpr(pipe.Cmds, thenScope),
+					g.cmdsExpr(pipe.Cmds, pipe, thenScope),
 					thenScope,
 				),
-				Body: body,
 			}
 		}
+		// The body is generated after the condition, cause the latter
+		// declares the variables the former uses.
+		stmt.Body = g.listNodeStmt(n.List, thenScope)
 		if n.ElseList != nil {
 			stmt.Else = g.listNodeStmt(n.ElseList, ifScope.ElseScope)
 		}
 		return stmt
 	}
 	if n, ok := n.(*parse.RangeNode); ok {
-		iter := g.cmdsExpr(n.Pipe.Cmds, scope)
-		scope := scopes.NewRangeScope(scope, n)
-		x := scope.List()
-		stmt := &ast.IfStmt{
-			Init: &ast.AssignStmt{
-				Tok: token.DEFINE,
-				Lhs: []ast.Expr{x},
-				Rhs: []ast.Expr{
-					iter,
-				},
-			},
-			Cond: g.nonEmptyCond(x, scope),
-			Body: &ast.BlockStmt{
-				List: []ast.Stmt{
-					&ast.RangeStmt{
-						Key:   scope.Key(),
-						Value: scope.Value(),
-						Tok:   token.DEFINE,
-						X:     x,
-						Body:  g.listNodeStmt(n.List, scope),
-					},
-				},
-			},
-		}
-		if n.ElseList != nil {
-			stmt.Else = g.listNodeStmt(n.ElseList, scope.ElseScope)
-		}
-		return stmt
+		return g.rangeStmt(n, scope)
 	}
 	if n, ok := n.(*parse.WithNode); ok {
-		expr := g.cmdsExpr(n.Pipe.Cmds, scope)
+		expr := g.cmdsExpr(n.Pipe.Cmds, n.Pipe, scope)
 		scope := scopes.NewWithScope(scope, n)
 		x := scope.Dot()
+		g.typed(x, g.typeOf(expr))
 		stmt := &ast.IfStmt{
 			Init: &ast.AssignStmt{
 				Tok: token.DEFINE,
@@ -115,31 +97,462 @@ func (g *Generator) nodeStmt(n parse.Node, scope scopes.Scope) ast.Stmt {
 		return stmt
 	}
 	if n, ok := n.(*parse.TemplateNode); ok {
-		name := n.Name
-		if s, _, ok := strings.Cut(name, "$htmltemplate_"); ok {
-			// unmangle template name
-			name = s
+		return g.templateNodeStmt(n, scope)
+	}
+	return g.writeExprStmt(g.nodeExpr(n, scope), scope)
+}
+
+func (g *Generator) templateNodeStmt(n *parse.TemplateNode, scope scopes.Scope) ast.Stmt {
+	name := unmangleTmplName(n.Name)
+	args := []ast.Expr{g.outIdent}
+	if n.Pipe != nil {
+		args = append(args, g.cmdsExpr(n.Pipe.Cmds, n.Pipe, scope))
+	}
+	if _, defined := g.tmpls[name]; !defined {
+		// The external one is passed as an argument
+		if isDerivedTmplName(n.Name) {
+			g.warnf(n, "template %q is called in the %s context, but its output isn't escaped for it", name, tmplContext(n.Name))
 		}
 		g.usedTmpls[name] = true
-		args := []ast.Expr{g.outIdent}
-		if n.Pipe != nil {
-			args = append(args, g.cmdsExpr(n.Pipe.Cmds, scope))
+		return g.tmplCallStmt(g.tmplParam(name), args)
+	}
+	// The template is generated in the same file, so it's called
+	// directly (e.g. `RenderFoo(output, data, errOutput)`). html/template
+	// derives a template for each context it's called in, so the
+	// matching variant is called (e.g. `RenderFooInAttr`).
+	fn, ok := g.tmpls[n.Name]
+	if !ok {
+		g.errorf(n, "no variant of template %q for the %s context", name, tmplContext(n.Name))
+		fn = g.tmpls[name]
+	}
+	if n.Pipe == nil {
+		args = append(args, g.zeroDataExpr())
+	}
+	call := g.tmplCallStmt(ast.NewIdent(fn), append(slices.Clone(args), g.overridesOf(fn)...))
+	override, ok := g.overrides[name]
+	if !ok || g.staticBlocks {
+		return call
+	}
+	if isDerivedTmplName(n.Name) {
+		g.warnf(n, "block %q is called in the %s context, but the output of its override isn't escaped for it", name, tmplContext(n.Name))
+	}
+	// The block's default body is rendered if it's not overridden:
+	//
+	//	if content != nil {
+	//		content(output, data, errOutput)
+	//	} else {
+	//		RenderFooContent(output, data, errOutput)
+	//	}
+	return &ast.IfStmt{
+		Cond: &ast.BinaryExpr{
+			X:  override,
+			Op: token.NEQ,
+			Y:  nilIdent,
+		},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{g.tmplCallStmt(override, args)},
+		},
+		Else: &ast.BlockStmt{
+			List: []ast.Stmt{call},
+		},
+	}
+}
+
+// Calls the template's function, e.g. `RenderFoo(output, data, errOutput)`,
+// or `tmtr.Check(errOutput, RenderFoo(output, data))` if it returns the
+// error (see `GeneratorOptions.ReturnErrors`).
+func (g *Generator) tmplCallStmt(fn ast.Expr, args []ast.Expr) ast.Stmt {
+	if !g.returnErrors {
+		return exprStmt(&ast.CallExpr{
+			Fun:  fn,
+			Args: append(args, g.eoutIdent),
+		})
+	}
+	return exprStmt(&ast.CallExpr{
+		Fun: &ast.SelectorExpr{
+			X:   g.useFuncs(),
+			Sel: checkIdent,
+		},
+		Args: []ast.Expr{
+			g.eoutIdent,
+			&ast.CallExpr{
+				Fun:  fn,
+				Args: args,
+			},
+		},
+	})
+}
+
+// Returns the arguments overriding the blocks the function of a defined
+// template calls (see `blockOverrides`).
+func (g *Generator) overridesOf(fn string) []ast.Expr {
+	list := make([]ast.Expr, 0)
+	for _, b := range g.tmplBlocks[fn] {
+		list = append(list, g.overrides[b])
+	}
+	return list
+}
+
+func (g *Generator) rangeStmt(n *parse.RangeNode, scope scopes.Scope) ast.Stmt {
+	iter := g.cmdsExpr(n.Pipe.Cmds, n.Pipe, scope)
+	rs := scopes.NewRangeScope(scope, n)
+	t := g.valueType(iter)
+	if isInterface(t) {
+		return g.dynamicRangeStmt(n, iter, rs)
+	}
+	if t == nil && g.sortMaps {
+		// The untyped values can't be ranged over dynamically, since their
+		// fields are accessed statically in the body
+		g.warnf(n, "the range type is unknown, so a map is ranged over in random order")
+	}
+	x := rs.List()
+	g.typed(x, t)
+	kt, vt := rangeTypes(t)
+	if k := rs.Key(); k != util.UnderscoreIdent {
+		g.typed(k, kt)
+	}
+	kind := rangeKindOf(t)
+	if kind == rangeSeq2 && len(n.Pipe.Decl) < 2 {
+		// The only variable (or dot) is the first value
+		vt = kt
+	}
+	g.typed(rs.Value(), vt)
+	if (kind == rangeInt || kind == rangeSeq) && len(n.Pipe.Decl) > 1 {
+		ts := t.String()
+		if g.env != nil {
+			ts = g.env.typeString(t)
+		}
+		g.errorf(n, "can't use %s to iterate over more than one variable", ts)
+	}
+	switch kind {
+	case rangeInt:
+		return g.intRangeStmt(n, iter, rs)
+	case rangeChan, rangeSeq, rangeSeq2:
+		return g.iterRangeStmt(n, iter, kind, rs)
+	}
+	m, isMap := underlyingMap(t)
+	var sortFn *ast.Ident
+	if isMap && g.sortMaps {
+		if sortFn = sortedKeysFunc(m.Key()); sortFn == nil {
+			g.warnf(n, "the keys of %s can't be sorted, so it's ranged over in random order", g.env.typeString(t))
 		}
-		args = append(args, g.eoutIdent)
-		expr := &ast.CallExpr{
-			Fun:  ast.NewIdent(name),
-			Args: args,
+	}
+	var loop ast.Stmt
+	if sortFn != nil {
+		loop = g.sortedMapRangeStmt(n, sortFn, rs)
+	} else {
+		loop = &ast.RangeStmt{
+			Key:   rs.Key(),
+			Value: rs.Value(),
+			Tok:   token.DEFINE,
+			X:     x,
+			Body:  g.rangeBodyStmt(n.List, false, rs),
 		}
-		return exprStmt(expr)
 	}
-	return g.writeExprStmt(g.nodeExpr(n, scope), scope)
+	stmt := &ast.IfStmt{
+		Init: &ast.AssignStmt{
+			Tok: token.DEFINE,
+			Lhs: []ast.Expr{x},
+			Rhs: []ast.Expr{iter},
+		},
+		Cond: g.nonEmptyCond(x, rs),
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{loop},
+		},
+	}
+	if n.ElseList != nil {
+		stmt.Else = g.listNodeStmt(n.ElseList, rs.ElseScope)
+	}
+	return stmt
 }
 
+// Maps are ranged over in the sorted key order like text/template does:
+//
+//	for _, k := range tmtr.SortedKeys(list) {
+//		v := list[k]
+//		...
+//	}
+//
+// The boolean keys are sorted by `tmtr.SortedBoolKeys` (false goes first).
+func (g *Generator) sortedMapRangeStmt(n *parse.RangeNode, sortFn *ast.Ident, scope *scopes.RangeScope) ast.Stmt {
+	x := scope.List()
+	key := scope.Key()
+	if key == util.UnderscoreIdent {
+		key = scopes.Uniq(scope, "key")
+	}
+	body := g.rangeBodyStmt(n.List, false, scope)
+	body.List = append([]ast.Stmt{
+		&ast.AssignStmt{
+			Tok: token.DEFINE,
+			Lhs: []ast.Expr{scope.Value()},
+			Rhs: []ast.Expr{
+				&ast.IndexExpr{X: x, Index: key},
+			},
+		},
+	}, body.List...)
+	return &ast.RangeStmt{
+		Key:   util.UnderscoreIdent,
+		Value: key,
+		Tok:   token.DEFINE,
+		X: &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   g.useFuncs(),
+				Sel: sortFn,
+			},
+			Args: []ast.Expr{x},
+		},
+		Body: body,
+	}
+}
+
+// Integers are iterated like `for i := range n`, and `{{else}}` is
+// executed if `n <= 0`.
+func (g *Generator) intRangeStmt(n *parse.RangeNode, iter ast.Expr, scope *scopes.RangeScope) ast.Stmt {
+	x := scope.List()
+	stmt := &ast.IfStmt{
+		Init: &ast.AssignStmt{
+			Tok: token.DEFINE,
+			Lhs: []ast.Expr{x},
+			Rhs: []ast.Expr{iter},
+		},
+		Cond: &ast.BinaryExpr{
+			X:  x,
+			Op: token.GTR,
+			Y:  &ast.BasicLit{Kind: token.INT, Value: "0"},
+		},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.RangeStmt{
+					Key:  scope.Value(),
+					Tok:  token.DEFINE,
+					X:    x,
+					Body: g.rangeBodyStmt(n.List, false, scope),
+				},
+			},
+		},
+	}
+	if n.ElseList != nil {
+		stmt.Else = g.listNodeStmt(n.ElseList, scope.ElseScope)
+	}
+	return stmt
+}
+
+// Channels and iterator functions (e.g. `iter.Seq`) can't be checked for
+// emptiness beforehand, so the iterations are counted if `{{else}}` has to
+// be executed (or the index of a channel's element is used):
+//
+//	{
+//		list, i := data.Chan, -1
+//		if list != nil {
+//			for elem := range list {
+//				i++
+//				...
+//			}
+//		}
+//		if i < 0 {
+//			...
+//		}
+//	}
+//
+// The nil ones are empty (ranging over a nil channel blocks forever).
+func (g *Generator) iterRangeStmt(n *parse.RangeNode, iter ast.Expr, kind rangeKind, scope *scopes.RangeScope) ast.Stmt {
+	x := scope.List()
+	loop := &ast.RangeStmt{
+		Key: scope.Value(),
+		Tok: token.DEFINE,
+		X:   x,
+	}
+	if kind == rangeSeq2 && len(n.Pipe.Decl) > 1 {
+		loop.Key, loop.Value = scope.Key(), scope.Value()
+	}
+	var counter *ast.Ident
+	if kind == rangeChan && len(n.Pipe.Decl) > 1 {
+		counter = scope.Key()
+	} else if n.ElseList != nil {
+		counter = scopes.Uniq(scope, "i")
+	}
+	loop.Body = g.rangeBodyStmt(n.List, false, scope)
+	if counter != nil {
+		loop.Body.List = append([]ast.Stmt{
+			&ast.IncDecStmt{X: counter, Tok: token.INC},
+		}, loop.Body.List...)
+	}
+	cond := &ast.BinaryExpr{
+		X:  x,
+		Op: token.NEQ,
+		Y:  nilIdent,
+	}
+	if counter == nil {
+		return &ast.IfStmt{
+			Init: &ast.AssignStmt{
+				Tok: token.DEFINE,
+				Lhs: []ast.Expr{x},
+				Rhs: []ast.Expr{iter},
+			},
+			Cond: cond,
+			Body: &ast.BlockStmt{List: []ast.Stmt{loop}},
+		}
+	}
+	block := &ast.BlockStmt{
+		List: []ast.Stmt{
+			&ast.AssignStmt{
+				Tok: token.DEFINE,
+				Lhs: []ast.Expr{x, counter},
+				Rhs: []ast.Expr{
+					iter,
+					&ast.UnaryExpr{
+						Op: token.SUB,
+						X:  &ast.BasicLit{Kind: token.INT, Value: "1"},
+					},
+				},
+			},
+			&ast.IfStmt{
+				Cond: cond,
+				Body: &ast.BlockStmt{List: []ast.Stmt{loop}},
+			},
+		},
+	}
+	if n.ElseList != nil {
+		block.List = append(block.List, &ast.IfStmt{
+			Cond: &ast.BinaryExpr{
+				X:  counter,
+				Op: token.LSS,
+				Y:  &ast.BasicLit{Kind: token.INT, Value: "0"},
+			},
+			Body: g.listNodeStmt(n.ElseList, scope.ElseScope),
+		})
+	}
+	return block
+}
+
+// Ranges over a value of an interface type at runtime:
+//
+//	if list := x; tmtr.IsTrue(list) {
+//		tmtr.Range(errOutput, list, 2, func(k, v any) bool {
+//			...
+//			return true
+//		})
+//	}
+//
+// So `{{break}}` and `{{continue}}` return false and true respectively. The
+// number of the declared variables is passed, cause the only one gets the
+// first value of `iter.Seq2`. `tmtr.Range` reports if there were any
+// elements, cause an empty channel or iterator is true, so `{{else}}`
+// depends on it:
+//
+//	if list := x; !tmtr.Range(errOutput, list, 2, func(k, v any) bool { ... }) {
+//		...
+//	}
+func (g *Generator) dynamicRangeStmt(n *parse.RangeNode, iter ast.Expr, scope *scopes.RangeScope) ast.Stmt {
+	x := scope.List()
+	g.typed(x, anyType)
+	k, v := scope.Key(), scope.Value()
+	if k != util.UnderscoreIdent {
+		g.typed(k, anyType)
+	}
+	g.typed(v, anyType)
+	body := g.rangeBodyStmt(n.List, true, scope)
+	if l := len(body.List); l == 0 || !isReturnStmt(body.List[l-1]) {
+		body.List = append(body.List, &ast.ReturnStmt{
+			Results: []ast.Expr{ast.NewIdent("true")},
+		})
+	}
+	call := &ast.CallExpr{
+		Fun: &ast.SelectorExpr{
+			X:   g.useFuncs(),
+			Sel: rangeIdent,
+		},
+		Args: []ast.Expr{
+			g.eoutIdent,
+			x,
+			&ast.BasicLit{
+				Kind:  token.INT,
+				Value: strconv.Itoa(len(n.Pipe.Decl)),
+			},
+			&ast.FuncLit{
+				Type: &ast.FuncType{
+					Params: &ast.FieldList{
+						List: []*ast.Field{
+							{
+								Names: []*ast.Ident{k, v},
+								Type:  ast.NewIdent("any"),
+							},
+						},
+					},
+					Results: &ast.FieldList{
+						List: []*ast.Field{
+							{Type: ast.NewIdent("bool")},
+						},
+					},
+				},
+				Body: body,
+			},
+		},
+	}
+	init := &ast.AssignStmt{
+		Tok: token.DEFINE,
+		Lhs: []ast.Expr{x},
+		Rhs: []ast.Expr{iter},
+	}
+	if n.ElseList != nil {
+		return &ast.IfStmt{
+			Init: init,
+			Cond: &ast.UnaryExpr{Op: token.NOT, X: call},
+			Body: g.listNodeStmt(n.ElseList, scope.ElseScope),
+		}
+	}
+	return &ast.IfStmt{
+		Init: init,
+		Cond: g.nonEmptyCond(x, scope),
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{exprStmt(call)},
+		},
+	}
+}
+
+func (g *Generator) rangeBodyStmt(list *parse.ListNode, dynamic bool, scope scopes.Scope) *ast.BlockStmt {
+	g.ranges = append(g.ranges, dynamic)
+	defer func() { g.ranges = g.ranges[:len(g.ranges)-1] }()
+	body := g.listNodeStmt(list, scope)
+	if g.outParam != nil {
+		body.List = append([]ast.Stmt{g.failedOutputStmt()}, body.List...)
+	}
+	return body
+}
+
+// Reports if the innermost range being generated is a dynamic one.
+func (g *Generator) inDynamicRange() bool {
+	l := len(g.ranges)
+	return l > 0 && g.ranges[l-1]
+}
+
+func isReturnStmt(s ast.Stmt) bool {
+	_, ok := s.(*ast.ReturnStmt)
+	return ok
+}
+
+// Values of the plain kinds (see `plainKind`) are written by the typed
+// functions (e.g. `tmtr.WriteString`), so they aren't boxed.
 func (g *Generator) writeUnescapedExprStmt(expr ast.Expr, scope scopes.Scope) ast.Stmt {
+	fn := writeIdent
+	switch plainKind(g.typeOf(expr)) {
+	case plainString:
+		fn = writeStringIdent
+		expr = g.stringExpr(expr)
+	case plainInt:
+		fn = writeIntIdent
+		expr = g.convExpr(expr, types.Typ[types.Int64], "int64")
+	case plainUint:
+		fn = writeUintIdent
+		expr = g.convExpr(expr, types.Typ[types.Uint64], "uint64")
+	case plainBool:
+		fn = writeBoolIdent
+		expr = g.convExpr(expr, types.Typ[types.Bool], "bool")
+	}
 	return exprStmt(&ast.CallExpr{
 		Fun: &ast.SelectorExpr{
-			X:   g.useFuncs(scope),
-			Sel: writeIdent,
+			X:   g.useFuncs(),
+			Sel: fn,
 		},
 		Args: []ast.Expr{g.outIdent, expr, g.eoutIdent},
 	})
@@ -150,31 +563,49 @@ func (g *Generator) writeExprStmt(expr ast.Expr, scope scopes.Scope) ast.Stmt {
 }
 
 func (g *Generator) listNodeStmt(list *parse.ListNode, scope scopes.Scope) *ast.BlockStmt {
-	body := make([]ast.Stmt, len(list.Nodes))
-	for i, n := range list.Nodes {
-		body[i] = g.nodeStmt(n, scope)
+	body := make([]ast.Stmt, 0, len(list.Nodes))
+	for _, n := range list.Nodes {
+		body = append(body, g.nodeMarkers(n)...)
+		body = append(body, g.recoverStmts(n, g.guardedStmts(n, scope))...)
 	}
 	return &ast.BlockStmt{
 		List: body,
 	}
 }
 
-func (g *Generator) pipeAssignStmt(pipe *parse.PipeNode, scope scopes.Scope) *ast.AssignStmt {
-	if pipe.Decl == nil {
+// The variables are looked up in the `declScope` (e.g. `{{if $x := ...}}`
+// declares `$x` in the "then" one).
+func (g *Generator) pipeAssignStmt(pipe *parse.PipeNode, scope, declScope scopes.Scope) *ast.AssignStmt {
+	if len(pipe.Decl) == 0 {
 		return nil
 	}
+	rhs := g.cmdsExpr(pipe.Cmds, pipe, scope)
+	value := rhs
+	tok := token.DEFINE
+	if pipe.IsAssign {
+		tok = token.ASSIGN
+	}
 	lhs := make([]ast.Expr, 0, len(pipe.Decl))
 	for _, decl := range pipe.Decl {
 		for _, s := range decl.Ident {
 			s = util.TrimDollarPrefix(s)
-			lhs = append(lhs, ast.NewIdent(s))
+			id := scopes.Lookup(declScope, s)
+			if id == nil {
+				id = ast.NewIdent(s)
+			}
+			if tok == token.DEFINE {
+				g.varDecls[id] = decl
+				rhs = g.declValueExpr(decl, rhs)
+				g.typed(id, g.typeOf(rhs))
+			}
+			// The values are collected to find the variables of
+			// different types (see `widenVars`)
+			if d, ok := g.varDecls[id]; ok {
+				g.varAssigns[d] = append(g.varAssigns[d], value)
+			}
+			lhs = append(lhs, id)
 		}
 	}
-	rhs := g.cmdsExpr(pipe.Cmds, scope)
-	tok := token.DEFINE
-	if pipe.IsAssign {
-		tok = token.ASSIGN
-	}
 	return &ast.AssignStmt{
 		Tok: tok,
 		Lhs: lhs,
@@ -184,10 +615,10 @@ func (g *Generator) pipeAssignStmt(pipe *parse.PipeNode, scope scopes.Scope) *as
 
 func (g *Generator) actionNodeStmt(node *parse.ActionNode, scope scopes.Scope) ast.Stmt {
 	pipe := node.Pipe
-	if pipe.Decl != nil {
-		return g.pipeAssignStmt(pipe, scope)
+	if len(pipe.Decl) > 0 {
+		return g.pipeAssignStmt(pipe, scope, scope)
 	} else {
-		expr := g.cmdsExpr(pipe.Cmds, scope)
+		expr := g.cmdsExpr(pipe.Cmds, pipe, scope)
 		return g.writeExprStmt(expr, scope)
 	}
 }
@@ -195,7 +626,7 @@ func (g *Generator) actionNodeStmt(node *parse.ActionNode, scope scopes.Scope) a
 func (g *Generator) nonEmptyCond(x ast.Expr, scope scopes.Scope) ast.Expr {
 	return &ast.CallExpr{
 		Fun: &ast.SelectorExpr{
-			X:   g.useFuncs(scope),
+			X:   g.useFuncs(),
 			Sel: isTrueIdent,
 		},
 		Args: []ast.Expr{x},
@@ -0,0 +1,113 @@
+package gen
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strings"
+)
+
+// Type-checks the generated source (`src`) against the output file's package
+// (named `opts.Package`). The source is expected to contain `//line`
+// directives, so the errors could be reported at the template positions.
+// Returns the type errors of the generated file as diagnostics.
+func TypeCheck(src []byte, opts GeneratorOptions) (Diagnostics, error) {
+	out, err := filepath.Abs(opts.OutFile)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(out)
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, out, src, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	// The existing output file could be outdated, so it's replaced with
+	// a stub importing the same packages to load them as well.
+	var stub strings.Builder
+	fmt.Fprintf(&stub, "package %s\n", file.Name.Name)
+	for _, s := range file.Imports {
+		fmt.Fprintf(&stub, "import _ %s\n", s.Path.Value)
+	}
+	pkg, err := loadPackage(opts, stub.String(), fset)
+	if err != nil {
+		return nil, fmt.Errorf("typecheck: %w", err)
+	}
+	files := make([]*ast.File, 0, len(pkg.Syntax))
+	for _, f := range pkg.Syntax {
+		if fset.File(f.Pos()).Name() != out {
+			files = append(files, f)
+		}
+	}
+	gf, err := parser.ParseFile(fset, out, src, 0)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, gf)
+	lines := strings.Split(string(src), "\n")
+	diags := &diagnostics{}
+	conf := types.Config{
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			if p, ok := pkg.Imports[path]; ok && p.Types != nil {
+				return p.Types, nil
+			}
+			return nil, fmt.Errorf("can't find import: %q", path)
+		}),
+		Error: func(err error) {
+			var te types.Error
+			if !errors.As(err, &te) {
+				return
+			}
+			raw := fset.PositionFor(te.Pos, false)
+			// Other files are not our business
+			if raw.Filename != out {
+				return
+			}
+			pos := fset.Position(te.Pos)
+			diags.add(Diagnostic{
+				File:     relFilename(pos.Filename, dir),
+				Line:     pos.Line,
+				Col:      stmtColumn(pos, raw, lines),
+				Severity: SeverityError,
+				Message:  te.Msg,
+				Err:      err,
+			})
+		},
+	}
+	conf.Check(pkg.PkgPath, fset, files, nil)
+	return diags.list, nil
+}
+
+// A directive positions the beginning of a statement, so the error's column
+// is the statement's one plus the error's offset in the generated code.
+// Returns the statement's column (i.e. the template action's one), or zero
+// if unknown.
+func stmtColumn(pos, raw token.Position, lines []string) int {
+	if pos.Column == 0 || raw.Line < 1 || raw.Line > len(lines) {
+		return 0
+	}
+	l := lines[raw.Line-1]
+	indent := len(l) - len(strings.TrimLeft(l, " \t"))
+	if col := pos.Column - (raw.Column - 1 - indent); col > 0 {
+		return col
+	}
+	return 0
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (fn importerFunc) Import(path string) (*types.Package, error) {
+	return fn(path)
+}
+
+// Returns the filename relative to the directory if it's inside.
+func relFilename(name, dir string) string {
+	if rel, err := filepath.Rel(dir, name); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return name
+}
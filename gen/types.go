@@ -0,0 +1,521 @@
+package gen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Type information of the output file's package
+type typeEnv struct {
+	pkg *types.Package
+	// data types by their expressions (e.g. "[]myData")
+	types map[string]types.Type
+	// imported packages by their names (see `GeneratorOptions.Imports`)
+	imports map[string]*types.Package
+	// the names of the imported packages by their paths
+	importNames map[string]string
+}
+
+// The names of the variables declared by the stub to resolve the data types
+func stubTypeVar(i int) string {
+	return fmt.Sprintf("_tmtr_type_%d", i)
+}
+
+// Returns all the data types of the options (`-type` and `-tpl` ones)
+func dataTypes(opts GeneratorOptions) []string {
+	res := []string{opts.DataType}
+	for _, t := range opts.Tmpls {
+		if len(t.DataType) > 0 {
+			res = append(res, t.DataType)
+		}
+	}
+	return res
+}
+
+// Returns the source of a file declaring a variable for each data type, so
+// they're resolved by type-checking the package. The imports aren't named,
+// so they get the names of the packages (e.g. "yaml" for "gopkg.in/yaml.v3").
+func typeEnvStub(opts GeneratorOptions) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "package %s\n", opts.Package)
+	for _, path := range opts.Imports {
+		fmt.Fprintf(&b, "import %q\n", path)
+	}
+	for i, t := range dataTypes(opts) {
+		fmt.Fprintf(&b, "var %s %s\n", stubTypeVar(i), t)
+	}
+	return b.String()
+}
+
+func newTypeEnv(pkg *types.Package, opts GeneratorOptions, diags *diagnostics) *typeEnv {
+	env := &typeEnv{
+		pkg:         pkg,
+		types:       make(map[string]types.Type),
+		imports:     make(map[string]*types.Package),
+		importNames: make(map[string]string),
+	}
+	for i, t := range dataTypes(opts) {
+		obj := pkg.Scope().Lookup(stubTypeVar(i))
+		if obj == nil || obj.Type() == types.Typ[types.Invalid] {
+			diags.add(Diagnostic{
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("can't resolve type %q, so it's considered unknown", t),
+			})
+			continue
+		}
+		env.types[t] = obj.Type()
+	}
+	for _, p := range pkg.Imports() {
+		if slices.Contains(opts.Imports, p.Path()) {
+			env.imports[p.Name()] = p
+			env.importNames[p.Path()] = p.Name()
+		}
+	}
+	return env
+}
+
+// Returns the name of the imported package, or guesses it by the path if
+// the types are unknown (see `guessImportName`).
+func (env *typeEnv) importName(path string) string {
+	if env != nil {
+		if name, ok := env.importNames[path]; ok {
+			return name
+		}
+	}
+	return guessImportName(path)
+}
+
+// Loads the output file's package replacing the file with the stub.
+func loadPackage(opts GeneratorOptions, stub string, fset *token.FileSet) (*packages.Package, error) {
+	out, err := filepath.Abs(opts.OutFile)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(out)
+	cfg := &packages.Config{
+		Mode: packages.NeedName |
+			packages.NeedFiles |
+			packages.NeedCompiledGoFiles |
+			packages.NeedSyntax |
+			packages.NeedImports |
+			packages.NeedDeps |
+			packages.NeedTypes,
+		Dir:     dir,
+		Fset:    fset,
+		Overlay: map[string][]byte{out: []byte(stub)},
+	}
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pkgs {
+		if p.Name != opts.Package {
+			continue
+		}
+		for _, e := range p.Errors {
+			if e.Kind != packages.TypeError {
+				return nil, fmt.Errorf("loading %q failed: %w", p.PkgPath, e)
+			}
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("no package %q in %s", opts.Package, dir)
+}
+
+// Loads the type information. Returns nil if failed, so the generation
+// falls back to the untyped mode.
+func loadTypeEnv(opts GeneratorOptions, diags *diagnostics) *typeEnv {
+	pkg, err := loadPackage(opts, typeEnvStub(opts), token.NewFileSet())
+	if err != nil {
+		diags.add(Diagnostic{
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("no type information: %v", err),
+			Err:      err,
+		})
+		return nil
+	}
+	return newTypeEnv(pkg.Types, opts, diags)
+}
+
+// Returns nil if unknown.
+func (env *typeEnv) lookup(typ string) types.Type {
+	if env == nil {
+		return nil
+	}
+	return env.types[typ]
+}
+
+// Returns the type of the package-level function (or variable), which
+// could be used as a template one, or nil if there's no such.
+func (env *typeEnv) funcType(name string) types.Type {
+	if env == nil {
+		return nil
+	}
+	if obj := env.pkg.Scope().Lookup(name); obj != nil {
+		switch obj.(type) {
+		case *types.Func, *types.Var:
+			return obj.Type()
+		}
+	}
+	return nil
+}
+
+func (env *typeEnv) typeString(t types.Type) string {
+	return types.TypeString(t, types.RelativeTo(env.pkg))
+}
+
+var (
+	errorType = types.Universe.Lookup("error").Type()
+	anyType   = types.Universe.Lookup("any").Type()
+)
+
+// Values of interface types are evaluated dynamically (by reflection) at
+// runtime.
+func isInterface(t types.Type) bool {
+	if t == nil {
+		return false
+	}
+	_, ok := t.Underlying().(*types.Interface)
+	return ok
+}
+
+// Returns a signature of a function with no params and a single result.
+func funcReturning(t types.Type) *types.Signature {
+	res := types.NewTuple(types.NewVar(token.NoPos, nil, "", t))
+	return types.NewSignatureType(nil, nil, nil, nil, res, false)
+}
+
+func signatureOf(t types.Type) *types.Signature {
+	if t == nil {
+		return nil
+	}
+	sig, _ := t.Underlying().(*types.Signature)
+	return sig
+}
+
+// Template functions return a single value, or a value and an error.
+func returnsError(sig *types.Signature) bool {
+	res := sig.Results()
+	return res.Len() == 2 && types.Identical(res.At(1).Type(), errorType)
+}
+
+// Returns the type of the function's result as a template one, or nil
+// if the function can't be called by a template.
+func resultType(sig *types.Signature) types.Type {
+	if sig == nil {
+		return nil
+	}
+	if res := sig.Results(); res.Len() == 1 || returnsError(sig) {
+		return res.At(0).Type()
+	}
+	return nil
+}
+
+// Kinds of the values `fmt` prints as is, so they could be written and
+// escaped without it.
+type plainKindType int
+
+const (
+	plainNone plainKindType = iota
+	plainString
+	plainInt
+	plainUint
+	plainBool
+)
+
+// Returns `plainNone` if the type is unknown, not a basic one, or has a
+// method changing the way `fmt` prints it (e.g. `String()`).
+func plainKind(t types.Type) plainKindType {
+	if t == nil {
+		return plainNone
+	}
+	b, ok := t.Underlying().(*types.Basic)
+	if !ok {
+		return plainNone
+	}
+	ms := types.NewMethodSet(t)
+	for _, name := range []string{"String", "Error", "Format", "GoString"} {
+		if ms.Lookup(nil, name) != nil {
+			return plainNone
+		}
+	}
+	switch info := b.Info(); {
+	case info&types.IsString != 0:
+		return plainString
+	case info&types.IsUnsigned != 0:
+		return plainUint
+	case info&types.IsInteger != 0:
+		return plainInt
+	case info&types.IsBoolean != 0:
+		return plainBool
+	}
+	return plainNone
+}
+
+// Returns the name of the html/template's type (e.g. "HTML" for
+// `template.HTML`) or an empty string.
+func safeTypeName(t types.Type) string {
+	if n, ok := t.(*types.Named); ok {
+		if obj := n.Obj(); obj.Pkg() != nil && obj.Pkg().Path() == "html/template" {
+			return obj.Name()
+		}
+	}
+	return ""
+}
+
+// Returns `x` if it's already of the type, and `name(x)` otherwise (e.g.
+// `int64(x)`).
+func (g *Generator) convExpr(x ast.Expr, t types.Type, name string) ast.Expr {
+	if xt := g.typeOf(x); xt != nil && types.Identical(xt, t) {
+		return x
+	}
+	return g.typed(&ast.CallExpr{
+		Fun:  ast.NewIdent(name),
+		Args: []ast.Expr{x},
+	}, t)
+}
+
+func (g *Generator) stringExpr(x ast.Expr) ast.Expr {
+	return g.convExpr(x, types.Typ[types.String], "string")
+}
+
+// Returns the zero value of the data type, which is passed to a template
+// called without a pipeline (e.g. `{{template "foo"}}`): `nil` if the type
+// is nillable, and `*new(T)` otherwise.
+func (g *Generator) zeroDataExpr() ast.Expr {
+	typ := g.dataType
+	nillable := typ == "any" || typ == "interface{}" || strings.HasPrefix(typ, "*")
+	if t := g.env.lookup(typ); t != nil {
+		switch t.Underlying().(type) {
+		case *types.Pointer, *types.Interface, *types.Map, *types.Slice, *types.Chan, *types.Signature:
+			nillable = true
+		}
+	}
+	if nillable {
+		return nilIdent
+	}
+	return &ast.StarExpr{
+		X: &ast.CallExpr{
+			Fun:  ast.NewIdent("new"),
+			Args: []ast.Expr{ast.NewIdent(typ)},
+		},
+	}
+}
+
+// Records the type of the expression if known, and returns the expression.
+func (g *Generator) typed(x ast.Expr, t types.Type) ast.Expr {
+	if t != nil && g.env != nil {
+		g.exprTypes[x] = t
+	}
+	return x
+}
+
+// Returns nil if unknown.
+func (g *Generator) typeOf(x ast.Expr) types.Type {
+	if g.env == nil {
+		return nil
+	}
+	return g.exprTypes[x]
+}
+
+// Reports if the expression is addressable, so the methods with pointer
+// receivers could be called.
+func (g *Generator) addressable(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return x != nilIdent
+	case *ast.SelectorExpr:
+		if t := g.typeOf(x.X); t != nil {
+			if _, ok := t.Underlying().(*types.Pointer); ok {
+				return true
+			}
+		}
+		return g.addressable(x.X)
+	case *ast.IndexExpr:
+		if t := g.typeOf(x.X); t != nil {
+			switch t.Underlying().(type) {
+			case *types.Slice, *types.Pointer:
+				return true
+			case *types.Array:
+				return g.addressable(x.X)
+			}
+		}
+	}
+	return false
+}
+
+// Returns the types of a range statement's key and value. Nil means
+// unknown.
+func rangeTypes(t types.Type) (key, value types.Type) {
+	if t == nil {
+		return nil, nil
+	}
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		if a, ok := p.Elem().Underlying().(*types.Array); ok {
+			return types.Typ[types.Int], a.Elem()
+		}
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Slice:
+		return types.Typ[types.Int], u.Elem()
+	case *types.Array:
+		return types.Typ[types.Int], u.Elem()
+	case *types.Map:
+		return u.Key(), u.Elem()
+	case *types.Chan:
+		return types.Typ[types.Int], u.Elem()
+	case *types.Basic:
+		if u.Info()&types.IsInteger != 0 {
+			return nil, t
+		}
+	case *types.Signature:
+		if yield := yieldParams(u); yield != nil {
+			if yield.Len() == 1 {
+				return nil, yield.At(0).Type()
+			}
+			return yield.At(0).Type(), yield.At(1).Type()
+		}
+	}
+	return nil, nil
+}
+
+func underlyingMap(t types.Type) (*types.Map, bool) {
+	if t == nil {
+		return nil, false
+	}
+	m, ok := t.Underlying().(*types.Map)
+	return m, ok
+}
+
+func underlyingBasic(t types.Type) (*types.Basic, bool) {
+	if t == nil {
+		return nil, false
+	}
+	b, ok := t.Underlying().(*types.Basic)
+	return b, ok
+}
+
+// Reports if the values of the type could be sorted (e.g. strings or numbers,
+// see `tmtr.SortedKeys`).
+func isOrdered(t types.Type) bool {
+	b, ok := underlyingBasic(t)
+	return ok && b.Info()&types.IsOrdered != 0
+}
+
+// Returns the function sorting the map keys of the type (see
+// `tmtr.SortedKeys` & `tmtr.SortedBoolKeys`), or nil if they can't be sorted.
+func sortedKeysFunc(key types.Type) *ast.Ident {
+	b, ok := underlyingBasic(key)
+	switch {
+	case !ok:
+		return nil
+	case b.Info()&types.IsOrdered != 0:
+		return sortedKeysIdent
+	case b.Info()&types.IsBoolean != 0:
+		return sortedBoolKeysIdent
+	}
+	return nil
+}
+
+type rangeKind int
+
+const (
+	rangeList rangeKind = iota // slices, arrays, maps, or unknown
+	rangeInt
+	rangeChan
+	rangeSeq  // func(yield func(V) bool)
+	rangeSeq2 // func(yield func(K, V) bool)
+)
+
+func rangeKindOf(t types.Type) rangeKind {
+	if t == nil {
+		return rangeList
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		if u.Info()&types.IsInteger != 0 {
+			return rangeInt
+		}
+	case *types.Chan:
+		return rangeChan
+	case *types.Signature:
+		if yield := yieldParams(u); yield != nil {
+			if yield.Len() == 1 {
+				return rangeSeq
+			}
+			return rangeSeq2
+		}
+	}
+	return rangeList
+}
+
+// Returns the parameters of `yield` if it's an iterator function (e.g.
+// `iter.Seq`), or nil.
+func yieldParams(sig *types.Signature) *types.Tuple {
+	if sig.Params().Len() != 1 || sig.Results().Len() != 0 {
+		return nil
+	}
+	yield, ok := sig.Params().At(0).Type().Underlying().(*types.Signature)
+	if !ok || yield.Results().Len() != 1 || !types.Identical(yield.Results().At(0).Type(), types.Typ[types.Bool]) {
+		return nil
+	}
+	if l := yield.Params().Len(); l != 1 && l != 2 {
+		return nil
+	}
+	return yield.Params()
+}
+
+// Returns the type of `x[i]`, or nil if unknown.
+func indexType(t types.Type) types.Type {
+	if t == nil {
+		return nil
+	}
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		if a, ok := p.Elem().Underlying().(*types.Array); ok {
+			return a.Elem()
+		}
+	}
+	switch t := t.Underlying().(type) {
+	case *types.Slice:
+		return t.Elem()
+	case *types.Array:
+		return t.Elem()
+	case *types.Map:
+		return t.Elem()
+	case *types.Basic:
+		if t.Info()&types.IsString != 0 {
+			return types.Typ[types.Byte]
+		}
+	}
+	return nil
+}
+
+// Returns the type of `x[i:j]`, or nil if unknown.
+func sliceType(t types.Type) types.Type {
+	if t == nil {
+		return nil
+	}
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		if a, ok := p.Elem().Underlying().(*types.Array); ok {
+			return types.NewSlice(a.Elem())
+		}
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Slice:
+		return t
+	case *types.Array:
+		return types.NewSlice(u.Elem())
+	case *types.Basic:
+		if u.Info()&types.IsString != 0 {
+			return t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package gen
+
+import (
+	"go/ast"
+	"go/token"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// Go rejects the declared and unused variables, but templates don't care
+// (e.g. `{{range $i, $v := .}}` without `$i`), so the unused range
+// variables are replaced with `_`, and the other unused declarations are
+// followed by `_ = x`.
+//
+// A variable is the same identifier in all its uses, so the uses are counted
+// by pointers.
+func dropUnusedVars(body *ast.BlockStmt) {
+	uses := make(map[*ast.Ident]int)
+	countUses(body, uses)
+	unused := func(x ast.Expr) bool {
+		id, ok := x.(*ast.Ident)
+		return ok && id.Name != "_" && uses[id] == 0
+	}
+	astutil.Apply(body, nil, func(c *astutil.Cursor) bool {
+		switch n := c.Node().(type) {
+		case *ast.RangeStmt:
+			if n.Tok != token.DEFINE {
+				break
+			}
+			if n.Value != nil && unused(n.Value) {
+				n.Value = nil
+			}
+			if n.Key != nil && (unused(n.Key) || isBlankIdent(n.Key)) {
+				if n.Value == nil {
+					n.Key = nil
+				} else {
+					n.Key = ast.NewIdent("_")
+				}
+			}
+		case *ast.AssignStmt:
+			if n.Tok != token.DEFINE || c.Index() < 0 {
+				break
+			}
+			insertBlankAssigns(c, n.Lhs, unused)
+		case *ast.DeclStmt:
+			// e.g. `var x T` of the safe mode (see `guardedDeclStmts`)
+			if c.Index() < 0 {
+				break
+			}
+			var names []ast.Expr
+			for _, spec := range n.Decl.(*ast.GenDecl).Specs {
+				if spec, ok := spec.(*ast.ValueSpec); ok {
+					for _, id := range spec.Names {
+						names = append(names, id)
+					}
+				}
+			}
+			insertBlankAssigns(c, names, unused)
+		}
+		return true
+	})
+}
+
+// Inserts `_ = x` after the statement for each unused `x`.
+func insertBlankAssigns(c *astutil.Cursor, xs []ast.Expr, unused func(ast.Expr) bool) {
+	// The inserted statements are visited in the reverse order
+	for i := len(xs) - 1; i >= 0; i-- {
+		if x := xs[i]; unused(x) {
+			c.InsertAfter(&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("_")},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{x},
+			})
+		}
+	}
+}
+
+// Counts the uses of the identifiers, but not the assigned (or incremented)
+// ones, since they're declarations or assignments, which aren't uses in Go.
+func countUses(n ast.Node, uses map[*ast.Ident]int) {
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Ident:
+			uses[n]++
+		case *ast.AssignStmt:
+			for _, x := range n.Lhs {
+				if _, ok := x.(*ast.Ident); !ok {
+					countUses(x, uses)
+				}
+			}
+			for _, x := range n.Rhs {
+				countUses(x, uses)
+			}
+			return false
+		case *ast.ValueSpec:
+			if n.Type != nil {
+				countUses(n.Type, uses)
+			}
+			for _, x := range n.Values {
+				countUses(x, uses)
+			}
+			return false
+		case *ast.RangeStmt:
+			countUses(n.X, uses)
+			countUses(n.Body, uses)
+			return false
+		case *ast.IncDecStmt:
+			if _, ok := n.X.(*ast.Ident); ok {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func isBlankIdent(x ast.Expr) bool {
+	id, ok := x.(*ast.Ident)
+	return ok && id.Name == "_"
+}
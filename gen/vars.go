@@ -0,0 +1,185 @@
+package gen
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"text/template/parse"
+)
+
+// Template variables can be reassigned with values of different types (e.g.
+// `{{$x := 0}}{{if .Flag}}{{$x = .Name}}{{end}}`), but Go ones can't, so
+// such variables are declared of the type all the values are assignable to
+// (e.g. `x := any(0)`). The values are collected while generating, so it
+// returns true if the function has to be generated again with these types.
+func (g *Generator) widenVars() bool {
+	widened := false
+	for decl, values := range g.varAssigns {
+		if t := g.commonType(values); t != nil {
+			g.varTypes[decl] = t
+			widened = true
+		}
+	}
+	clear(g.varAssigns)
+	clear(g.varDecls)
+	return widened
+}
+
+// Returns the type to declare the variable of, or nil if the type of the
+// first value fits (or the types are unknown at all).
+func (g *Generator) commonType(values []ast.Expr) types.Type {
+	t := g.leastCommonType(values)
+	if t == nil {
+		return nil
+	}
+	if t0 := g.valueType(values[0]); t0 != nil && types.Identical(t, t0) {
+		return nil
+	}
+	return t
+}
+
+func (g *Generator) leastCommonType(values []ast.Expr) types.Type {
+	ts := make([]types.Type, len(values))
+	known := 0
+	for i, x := range values {
+		if ts[i] = g.valueType(x); ts[i] != nil {
+			known++
+		}
+	}
+	if known == 0 {
+		return nil
+	}
+	if known < len(ts) {
+		// Some types are unknown (e.g. in the untyped mode), so they're
+		// likely different
+		return anyType
+	}
+	same := true
+	for _, t := range ts[1:] {
+		same = same && types.Identical(t, ts[0])
+	}
+	if same {
+		return nil
+	}
+	// The least common type is the one of the values all the other ones
+	// are assignable to (e.g. an interface, or `int64` for `0` and
+	// `int64(1)`).
+	for _, t := range ts {
+		fits := g.typeExpr(t) != nil
+		for _, x := range values {
+			fits = fits && g.assignableTo(x, t)
+		}
+		if fits {
+			return t
+		}
+	}
+	return anyType
+}
+
+// Returns the type of the value, or the default one of the literal (or the
+// boolean expression) if the types are unknown.
+func (g *Generator) valueType(x ast.Expr) types.Type {
+	if t := g.typeOf(x); t != nil {
+		return t
+	}
+	switch x := x.(type) {
+	case *ast.BasicLit:
+		switch x.Kind {
+		case token.INT:
+			return types.Typ[types.Int]
+		case token.FLOAT:
+			return types.Typ[types.Float64]
+		case token.IMAG:
+			return types.Typ[types.Complex128]
+		case token.STRING:
+			return types.Typ[types.String]
+		}
+	case *ast.Ident:
+		if x.Name == "true" || x.Name == "false" {
+			return types.Typ[types.Bool]
+		}
+	case *ast.ParenExpr:
+		return g.valueType(x.X)
+	case *ast.BinaryExpr:
+		switch x.Op {
+		case token.LAND, token.LOR, token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			return types.Typ[types.Bool]
+		}
+	}
+	return nil
+}
+
+// Constants are assignable to the types of their kinds if they fit (e.g.
+// `0` to `int64`, but not `-1` to `uint8`).
+func (g *Generator) assignableTo(x ast.Expr, t types.Type) bool {
+	if xt := g.valueType(x); xt != nil && types.AssignableTo(xt, t) {
+		return true
+	}
+	b, ok := t.Underlying().(*types.Basic)
+	if !ok {
+		return false
+	}
+	info := b.Info()
+	switch x := x.(type) {
+	case *ast.BasicLit:
+		fits := false
+		switch x.Kind {
+		case token.INT:
+			fits = info&types.IsNumeric != 0
+		case token.FLOAT:
+			fits = info&(types.IsFloat|types.IsComplex) != 0
+		case token.IMAG:
+			fits = info&types.IsComplex != 0
+		case token.STRING:
+			fits = info&types.IsString != 0
+		}
+		if fits && info&types.IsNumeric != 0 {
+			// The conversion fails if the constant overflows (e.g.
+			// `uint8(-1)`)
+			_, err := types.Eval(token.NewFileSet(), nil, token.NoPos, b.Name()+"("+x.Value+")")
+			fits = err == nil
+		}
+		return fits
+	case *ast.Ident:
+		return (x.Name == "true" || x.Name == "false") && info&types.IsBoolean != 0
+	}
+	return false
+}
+
+// Returns the type's expression to convert to (e.g. `(*T)`), or nil if the
+// type can't be referred to (e.g. it's from the other package).
+func (g *Generator) typeExpr(t types.Type) ast.Expr {
+	if t == anyType {
+		return ast.NewIdent("any")
+	}
+	ok := true
+	s := types.TypeString(t, func(p *types.Package) string {
+		if g.env == nil || p != g.env.pkg {
+			ok = false
+		}
+		return ""
+	})
+	x, err := parser.ParseExpr(s)
+	if !ok || err != nil {
+		return nil
+	}
+	switch x.(type) {
+	case *ast.StarExpr, *ast.FuncType, *ast.ChanType:
+		return &ast.ParenExpr{X: x}
+	}
+	return x
+}
+
+// Converts the declared value to the variable's widened type (see
+// `widenVars`).
+func (g *Generator) declValueExpr(decl *parse.VariableNode, x ast.Expr) ast.Expr {
+	t, ok := g.varTypes[decl]
+	if !ok {
+		return x
+	}
+	return g.typed(&ast.CallExpr{
+		Fun:  g.typeExpr(t),
+		Args: []ast.Expr{x},
+	}, t)
+}
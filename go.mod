@@ -3,3 +3,10 @@ module github.com/apleshkov/tmtr
 go 1.22.2
 
 retract [v0.0.0-0, v0.1.11]
+
+require golang.org/x/tools v0.30.0
+
+require (
+	golang.org/x/mod v0.23.0 // indirect
+	golang.org/x/sync v0.11.0 // indirect
+)
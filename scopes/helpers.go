@@ -8,22 +8,22 @@ import (
 )
 
 func procPipe(pipe *parse.PipeNode, ns *names) []*ast.Ident {
-	if pipe.IsAssign || pipe.Decl == nil {
+	if pipe.IsAssign || len(pipe.Decl) == 0 {
 		return nil
 	}
 	idents := make([]*ast.Ident, 0)
 	for _, decl := range pipe.Decl {
 		for _, s := range decl.Ident {
-			s := util.TrimDollarPrefix(s)
-			id := ast.NewIdent(s)
-			ns.set(s, id)
-			idents = append(idents, id)
+			idents = append(idents, ns.declare(util.TrimDollarPrefix(s)))
 		}
 	}
 	return idents
 }
 
 func procList(list *parse.ListNode, ns *names) {
+	if list == nil {
+		return
+	}
 	for _, n := range list.Nodes {
 		if n, ok := n.(*parse.ActionNode); ok {
 			procPipe(n.Pipe, ns)
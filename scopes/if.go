@@ -11,7 +11,7 @@ type IfScope struct {
 
 func NewIfScope(parent Scope, node *parse.IfNode) *IfScope {
 	ns := newNames(parent.names())
-	if pipe := node.Pipe; pipe.Decl != nil {
+	if pipe := node.Pipe; len(pipe.Decl) > 0 {
 		procPipe(pipe, ns)
 	}
 	parent = &ifInitScope{
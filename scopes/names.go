@@ -1,20 +1,31 @@
 package scopes
 
-import "go/ast"
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
 
 type names struct {
 	parent *names
-	data   map[string]*ast.Ident
+	data   map[string]*ast.Ident // Go identifiers
+	vars   map[string]*ast.Ident // template variables without `$`
 }
 
 func newNames(parent *names) *names {
 	return &names{
 		parent: parent,
 		data:   make(map[string]*ast.Ident),
+		vars:   make(map[string]*ast.Ident),
 	}
 }
 
+// Reports if the Go identifier is taken, or it's a keyword or a predeclared
+// one (e.g. `type`, `len`, `string`), which can't be declared.
 func (ns *names) has(name string) bool {
+	if name == "_" || token.IsKeyword(name) || types.Universe.Lookup(name) != nil {
+		return true
+	}
 	_, has := ns.data[name]
 	if !has && ns.parent != nil {
 		return ns.parent.has(name)
@@ -22,6 +33,17 @@ func (ns *names) has(name string) bool {
 	return has
 }
 
+// Returns the identifier of the template variable.
+func (ns *names) get(name string) *ast.Ident {
+	if id, ok := ns.vars[name]; ok {
+		return id
+	}
+	if ns.parent != nil {
+		return ns.parent.get(name)
+	}
+	return nil
+}
+
 func (ns *names) set(name string, ident *ast.Ident) {
 	ns.data[name] = ident
 }
@@ -35,3 +57,14 @@ func (ns *names) uniq(name string) *ast.Ident {
 		return ident
 	}
 }
+
+// Declares the template variable, which is renamed if its name is taken
+// (e.g. `$type` is `type_`), so all its uses get the same identifier.
+func (ns *names) declare(name string) *ast.Ident {
+	if id, ok := ns.vars[name]; ok {
+		return id
+	}
+	id := ns.uniq(name)
+	ns.vars[name] = id
+	return id
+}
@@ -9,6 +9,7 @@ import (
 
 type RangeScope struct {
 	ns               *names
+	dollar           *ast.Ident
 	list, key, value *ast.Ident
 	ElseScope        *ListScope // nil if no ElseList
 }
@@ -32,10 +33,11 @@ func NewRangeScope(parent Scope, node *parse.RangeNode) *RangeScope {
 		value = ns.uniq("elem")
 	}
 	scope := &RangeScope{
-		ns:    ns,
-		list:  ns.uniq("list"),
-		key:   key,
-		value: value,
+		ns:     ns,
+		dollar: parent.Dollar(),
+		list:   ns.uniq("list"),
+		key:    key,
+		value:  value,
 	}
 	if node.ElseList != nil {
 		scope.ElseScope = NewListScope(parent, node.ElseList)
@@ -64,5 +66,5 @@ func (s *RangeScope) Dot() *ast.Ident {
 }
 
 func (s *RangeScope) Dollar() *ast.Ident {
-	return s.list
+	return s.dollar
 }
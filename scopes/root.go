@@ -10,8 +10,13 @@ type RootScope struct {
 	dot *ast.Ident
 }
 
-func NewRootScope(node *parse.ListNode) *RootScope {
+// The reserved names (e.g. the imports) are taken before the variables of
+// the node (can be nil) are declared, so the variables are renamed instead.
+func NewRootScope(node *parse.ListNode, reserved ...string) *RootScope {
 	ns := newNames(nil)
+	for _, name := range reserved {
+		ns.set(name, ast.NewIdent(name))
+	}
 	procList(node, ns)
 	return &RootScope{
 		ns:  ns,
@@ -13,3 +13,8 @@ type Scope interface {
 func Uniq(s Scope, name string) *ast.Ident {
 	return s.names().uniq(name)
 }
+
+// Returns the identifier declared for the name, or nil if there's no such.
+func Lookup(s Scope, name string) *ast.Ident {
+	return s.names().get(name)
+}
@@ -21,6 +21,30 @@ func TestRootScope(t *testing.T) {
 	util.TestAssert(t, !s.ns.has("y"))
 }
 
+func TestReservedNames(t *testing.T) {
+	root := parseNode(`{{$type := 0}}{{$len := 1}}{{$io := 2}}{{range $io := .}}{{$data := $io}}{{end}}`)
+	rs := NewRootScope(root, "io")
+	util.TestAssert(t, Lookup(rs, "type").Name == "type_")
+	util.TestAssert(t, Lookup(rs, "len").Name == "len_")
+	util.TestAssert(t, Lookup(rs, "io").Name == "io_")
+	util.TestAssert(t, Uniq(rs, "io").Name == "io__")
+	s := NewRangeScope(rs, root.Nodes[3].(*parse.RangeNode))
+	util.TestAssert(t, s.Value().Name == "io___")
+	util.TestAssert(t, Lookup(s, "io") == s.Value())
+	util.TestAssert(t, Lookup(s, "data").Name == "data_")
+}
+
+func TestLookup(t *testing.T) {
+	root := parseNode(`{{$x := 0}}{{range $v := .}}{{$y := 1}}{{end}}`)
+	rs := NewRootScope(root)
+	s := NewRangeScope(rs, root.Nodes[1].(*parse.RangeNode))
+	util.TestAssert(t, Lookup(s, "x") == Lookup(rs, "x"))
+	util.TestAssert(t, Lookup(s, "v") == s.Value())
+	util.TestAssert(t, Lookup(s, "y") != nil)
+	util.TestAssert(t, Lookup(rs, "y") == nil)
+	util.TestAssert(t, Lookup(s, "z") == nil)
+}
+
 func TestRangeScope1(t *testing.T) {
 	root := parseNode(`{{range .}}{{end}}`)
 	s := NewRangeScope(NewRootScope(root), root.Nodes[0].(*parse.RangeNode))
@@ -98,6 +122,16 @@ func TestWithScope2(t *testing.T) {
 	util.TestAssert(t, ws.Dot().Name == "v")
 }
 
+func TestDollar(t *testing.T) {
+	root := parseNode(`{{range .}}{{with .Foo}}{{$}}{{end}}{{end}}`)
+	rs := NewRootScope(root)
+	rn := root.Nodes[0].(*parse.RangeNode)
+	s := NewRangeScope(rs, rn)
+	util.TestAssert(t, s.Dollar() == rs.Dot())
+	ws := NewWithScope(s, rn.List.Nodes[0].(*parse.WithNode))
+	util.TestAssert(t, ws.Dollar() == rs.Dot())
+}
+
 func parseNode(text string) *parse.ListNode {
 	t := template.Must(template.New("test").Parse(text))
 	return t.Root
@@ -17,7 +17,7 @@ func NewWithScope(parent Scope, node *parse.WithNode) *WithScope {
 	scope := &WithScope{
 		ns:     ns,
 		dot:    ns.uniq("with"),
-		dollar: parent.Dot(),
+		dollar: parent.Dollar(),
 	}
 	if vars := procPipe(node.Pipe, ns); vars != nil {
 		scope.dot = vars[0]
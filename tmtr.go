@@ -1,11 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"go/format"
 	"go/token"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/apleshkov/tmtr/cli"
@@ -23,6 +25,20 @@ func ErrExit(err error) {
 	os.Exit(1)
 }
 
+// Prints the diagnostics and exits on error
+func DiagExit(diags gen.Diagnostics, err error, format gen.DiagFormat) {
+	if len(diags) > 0 {
+		diags.Fprint(os.Stderr, format)
+	}
+	if err != nil {
+		var ds gen.Diagnostics
+		if errors.As(err, &ds) {
+			os.Exit(1)
+		}
+		ErrExit(err)
+	}
+}
+
 func main() {
 	opts, err := cli.Parse()
 	if err != nil {
@@ -32,20 +48,36 @@ func main() {
 		}
 		ErrExit(err)
 	}
-	f, err := gen.GenerateFromFile(*opts)
-	if err != nil {
+	genOpts := *opts
+	// Type errors are mapped to the template positions by the directives
+	if opts.TypeCheck {
+		genOpts.LineDirectives = true
+	}
+	f, diags, err := gen.GenerateFromFile(genOpts)
+	DiagExit(diags, err, opts.DiagFormat)
+	// Format first, so nothing is written on failure
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by \"tmtr %s %s\"; DO NOT EDIT.\n", gen.Version, strings.Join(os.Args[1:], " "))
+	fmt.Fprintf(&buf, "\n")
+	fset := token.NewFileSet()
+	if err := format.Node(&buf, fset, f); err != nil {
 		ErrExit(err)
-	} else {
-		out, err := os.Create(opts.OutFile)
-		if err != nil {
-			ErrExit(err)
+	}
+	src := buf.Bytes()
+	if genOpts.LineDirectives {
+		src = gen.FixLineDirectives(src, filepath.Base(opts.OutFile))
+	}
+	if opts.TypeCheck {
+		diags, err := gen.TypeCheck(src, *opts)
+		DiagExit(diags, err, opts.DiagFormat)
+		if len(diags) > 0 {
+			os.Exit(1)
 		}
-		defer out.Close()
-		fmt.Fprintf(out, "// Code generated by \"tmtr %s %s\"; DO NOT EDIT.\n", gen.Version, strings.Join(os.Args[1:], " "))
-		fmt.Fprintf(out, "\n")
-		fset := token.NewFileSet()
-		if err := format.Node(out, fset, f); err != nil {
-			ErrExit(err)
+		if !opts.LineDirectives {
+			src = gen.StripLineDirectives(src)
 		}
 	}
+	if err := os.WriteFile(opts.OutFile, src, 0666); err != nil {
+		ErrExit(err)
+	}
 }